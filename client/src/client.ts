@@ -279,7 +279,9 @@ export class LinkDaveClient extends EventEmitter {
         if (
             !player.connecting &&
             player.voiceChannelId &&
-            (data.reason === DisconnectReason.ConnectionLost || data.reason === DisconnectReason.ConnectionFailed)
+            (data.reason === DisconnectReason.ConnectionLost ||
+                data.reason === DisconnectReason.ConnectionFailed ||
+                data.reason === DisconnectReason.ReconnectLimit)
         ) {
             player.disconnect();
         } else {
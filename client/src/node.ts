@@ -4,7 +4,9 @@ import { RESTClient } from "./rest.js";
 import type {
     ClientMessage, Events,
     PlayPayload,
+    PlayResponse,
     SeekPayload,
+    SeekResponse,
     ServerMessage,
     StatsPayload,
     VoiceUpdatePayload
@@ -12,6 +14,7 @@ import type {
 import {
     ClientOpCodes,
     EventName,
+    PROTOCOL_SUBPROTOCOL,
     Routes,
     ServerOpCodes
 } from "./types.js";
@@ -53,7 +56,7 @@ export class Node extends EventEmitter {
     #reconnectAttempts = 0;
     #reconnectTimeout: ReturnType<typeof setTimeout> | null = null;
     #state: NodeState = NodeState.Disconnected;
-    #stats: StatsPayload = { players: 0, playing_tracks: 0, uptime: 0, memory: 0 };
+    #stats: StatsPayload = { players: 0, playing_tracks: 0, uptime: 0, memory: 0, bandwidth: { downloaded_bytes: 0, sent_bytes: 0 } };
 
     constructor(options: NodeOptions) {
         super();
@@ -85,7 +88,7 @@ export class Node extends EventEmitter {
             if (this.#options.password) {
                 url.searchParams.set("password", this.#options.password);
             }
-            this.#ws = new WebSocket(url.toString());
+            this.#ws = new WebSocket(url.toString(), PROTOCOL_SUBPROTOCOL);
 
             const onOpen = () => {
                 this.#state = NodeState.Connected;
@@ -234,8 +237,12 @@ export class Node extends EventEmitter {
         this.#send(ClientOpCodes.PlayerMigrate, { guild_id: guildId });
     }
 
-    async sendPlay(guildId: string, data: PlayPayload) {
-        await this.rest.post(Routes.play(this.#requireSession(), guildId), data);
+    /**
+     * Resolves to where playback started when `start_time` was set, or to the
+     * queue position when `mode` queued the track instead.
+     */
+    sendPlay(guildId: string, data: PlayPayload) {
+        return this.rest.post<PlayResponse | SeekResponse | undefined>(Routes.play(this.#requireSession(), guildId), data);
     }
 
     async sendPause(guildId: string) {
@@ -250,14 +257,18 @@ export class Node extends EventEmitter {
         await this.rest.post(Routes.stop(this.#requireSession(), guildId));
     }
 
-    async sendSeek(guildId: string, data: SeekPayload) {
-        await this.rest.post(Routes.seek(this.#requireSession(), guildId), data);
+    sendSeek(guildId: string, data: SeekPayload) {
+        return this.rest.post<SeekResponse>(Routes.seek(this.#requireSession(), guildId), data);
     }
 
     async sendDisconnect(guildId: string) {
         await this.rest.delete(Routes.disconnect(this.#requireSession(), guildId));
     }
 
+    async sendDisconnectAll() {
+        await this.rest.delete(Routes.disconnectAll(this.#requireSession()));
+    }
+
     #requireSession(): string {
         if (!this.#sessionId) {
             throw new Error(`Node ${this.name} has no active session`);
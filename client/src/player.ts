@@ -276,9 +276,10 @@ export class Player {
         if (err) this.#node.emit(EventName.Error, err as Error);
     }
 
+    /** Resolves to where playback started when `startTime` was set. */
     async play(url: string, options: PlayOptions = {}, isFromQueue = false) {
         if (!isFromQueue) this.#queue._deactivate();
-        await this.#sendPlay(url, options);
+        return this.#sendPlay(url, options);
     }
 
     _onQueueEmpty() {
@@ -291,7 +292,7 @@ export class Player {
 
     async #sendPlay(url: string, options: PlayOptions = {}) {
         const filters = options.filters ?? this.#filters.toPayload();
-        await this.#node.sendPlay(this.#guildId, {
+        return this.#node.sendPlay(this.#guildId, {
             url,
             ...(options.startTime !== undefined && { start_time: options.startTime }),
             ...(options.requesterId !== undefined && { requester_id: options.requesterId }),
@@ -314,8 +315,9 @@ export class Player {
         this.#current = null;
     }
 
+    /** Resolves to where the seek landed, which can be a frame short of `position`. */
     async seek(position: number) {
-        await this.#node.sendSeek(this.#guildId, { position });
+        return this.#node.sendSeek(this.#guildId, { position });
     }
 
     async destroy() {
@@ -390,7 +392,11 @@ export class Player {
             this.#startTimer();
         }
 
-        this.#queue._onTrackEnd(data.reason !== TrackEndReason.Stopped && data.reason !== TrackEndReason.Replaced);
+        this.#queue._onTrackEnd(
+            data.reason !== TrackEndReason.Stopped &&
+            data.reason !== TrackEndReason.Replaced &&
+            data.reason !== TrackEndReason.Cleanup
+        );
     }
 
     _onVoiceConnect() {
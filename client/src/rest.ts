@@ -1,4 +1,4 @@
-import type { RESTError } from "./types.js";
+import type { RESTError, RESTResponse } from "./types.js";
 import { unwrap } from "./utils.js";
 
 export class RESTClient {
@@ -16,23 +16,23 @@ export class RESTClient {
         this.#password = password;
     }
 
-    async put(route: string, body?: unknown): Promise<void> {
-        await this.#request("PUT", route, body);
+    put<T = undefined>(route: string, body?: unknown): Promise<RESTResponse<T>> {
+        return this.#request("PUT", route, body) as Promise<RESTResponse<T>>;
     }
 
-    async post(route: string, body?: unknown): Promise<void> {
-        await this.#request("POST", route, body);
+    post<T = undefined>(route: string, body?: unknown): Promise<RESTResponse<T>> {
+        return this.#request("POST", route, body) as Promise<RESTResponse<T>>;
     }
 
-    async patch(route: string, body?: unknown): Promise<void> {
-        await this.#request("PATCH", route, body);
+    patch<T = undefined>(route: string, body?: unknown): Promise<RESTResponse<T>> {
+        return this.#request("PATCH", route, body) as Promise<RESTResponse<T>>;
     }
 
-    async delete(route: string): Promise<void> {
-        await this.#request("DELETE", route);
+    delete<T = undefined>(route: string): Promise<RESTResponse<T>> {
+        return this.#request("DELETE", route) as Promise<RESTResponse<T>>;
     }
 
-    async #request(method: string, route: string, body?: unknown): Promise<void> {
+    async #request(method: string, route: string, body?: unknown): Promise<unknown> {
         const headers: Record<string, string> = {
             "Content-Type": "application/json"
         };
@@ -57,5 +57,9 @@ export class RESTClient {
             const [error] = await unwrap(res.json() as Promise<RESTError>);
             throw new Error(error?.error ?? `REST request failed: ${res.status} ${res.statusText}`);
         }
+
+        // Routes that answer without a body, like most player commands, reply 204.
+        if (res.status === 204) return undefined;
+        return res.json();
     }
 }
\ No newline at end of file
@@ -1,6 +1,8 @@
 import type { Node } from "./node.js";
 import type { QueueItem } from "./queue.js";
 
+export const PROTOCOL_SUBPROTOCOL = "linkdave.v1";
+
 export enum ClientOpCodes {
     VoiceUpdate = 0,
     PlayerMigrate = 1
@@ -23,7 +25,8 @@ export enum TrackEndReason {
     Finished = "finished",
     Stopped = "stopped",
     Replaced = "replaced",
-    Error = "error"
+    Error = "error",
+    Cleanup = "cleanup"
 }
 
 export enum PlayerState {
@@ -48,10 +51,22 @@ export enum Filter {
     LowPass = 5
 }
 
+export type EqualizerPreset = "flat" | "bassboost" | "trebleboost" | "vocal";
+
+export interface EqualizerBand {
+    /** Band index from 0 (31 Hz) to 9 (16 kHz). */
+    band: number;
+    /** Gain in dB, between -12 and 12. */
+    gain: number;
+}
+
 export interface FiltersPayload {
     enabled?: Filter[];
     pitch?: number;
     speed?: number;
+    eq_preset?: EqualizerPreset;
+    /** Overrides individual bands of `eq_preset`. */
+    equalizer?: EqualizerBand[];
 }
 
 export type ServerMessage =
@@ -84,29 +99,63 @@ export interface VoiceUpdatePayload {
     event: VoiceServerEvent;
 }
 
+export enum PlayMode {
+    /** Ends the current track with reason `replaced`. */
+    Replace = "replace",
+    /** Queues the track while another one is playing, paused or loading. */
+    Enqueue = "enqueue"
+}
+
 export interface PlayPayload {
     url: string;
     start_time?: number;
     requester_id?: string;
     filters?: FiltersPayload;
+    mode?: PlayMode;
+    signal?: SignalType;
+}
+
+export enum SignalType {
+    Auto = "auto",
+    Music = "music",
+    Voice = "voice"
 }
 
 export interface GuildPayload {
     guild_id: string;
 }
 
+/** Sent instead of starting the track when `mode` queued it. */
+export interface PlayResponse {
+    mode: PlayMode;
+    queue_position: number;
+}
+
 export interface SeekPayload {
     position: number;
 }
 
+/** Where a seek landed, which can be short of the request on frame boundaries. */
+export interface SeekResponse {
+    position: number;
+    requested: number;
+    delta: number;
+}
+
 export interface ReadyPayload {
     session_id: string;
     resumed: boolean;
+    node?: string;
 }
 
 export interface PlayerUpdatePayload {
     guild_id: string;
     state: PlayerState;
+    /** Exact at `timestamp`, the node's unix milliseconds. */
+    position: number;
+    timestamp: number;
+    /** Round trip between the node and this client, omitted until measured. */
+    latency_ms?: number;
 }
 
 export interface TrackInfo {
@@ -121,10 +170,18 @@ export interface TrackStartPayload {
     track: TrackInfo;
 }
 
+export interface SourceStats {
+    partial_reads: number;
+    decode_errors: number;
+    reconnects: number;
+    underruns: number;
+}
+
 export interface TrackEndPayload {
     guild_id: string;
     track: TrackInfo;
     reason: TrackEndReason;
+    stats: SourceStats;
 }
 
 export interface TrackErrorPayload {
@@ -142,6 +199,9 @@ export interface QueueErrorPayload {
 export enum DisconnectReason {
     ConnectionLost = "connection_lost",
     ConnectionFailed = "connection_failed",
+    ReconnectLimit = "reconnect_limit",
+    SessionConflict = "session_conflict",
+    NodeOverloaded = "node_overloaded",
     Requested = "requested",
     Inactivity = "inactivity"
 }
@@ -157,10 +217,17 @@ export interface VoiceDisconnectPayload {
 }
 
 export interface StatsPayload {
+    node?: string;
     players: number;
     playing_tracks: number;
     uptime: number;
     memory: number;
+    bandwidth: Bandwidth;
+}
+
+export interface Bandwidth {
+    downloaded_bytes: number;
+    sent_bytes: number;
 }
 
 export interface PlayerMigratePayload {
@@ -179,6 +246,7 @@ export interface MigrateReadyPayload {
     state: PlayerState;
     requester_id?: string;
     filters?: FiltersPayload;
+    volume: number;
 }
 
 export interface ClosePayload {
@@ -245,5 +313,6 @@ export const Routes = {
     resume: (sessionId: string, guildId: string) => `/sessions/${sessionId}/players/${guildId}/resume` as const,
     stop: (sessionId: string, guildId: string) => `/sessions/${sessionId}/players/${guildId}/stop` as const,
     seek: (sessionId: string, guildId: string) => `/sessions/${sessionId}/players/${guildId}/seek` as const,
-    disconnect: (sessionId: string, guildId: string) => `/sessions/${sessionId}/players/${guildId}` as const
+    disconnect: (sessionId: string, guildId: string) => `/sessions/${sessionId}/players/${guildId}` as const,
+    disconnectAll: (sessionId: string) => `/sessions/${sessionId}/players` as const
 } as const;
\ No newline at end of file
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"crypto/tls"
 	"net/http"
 	"os"
 	"time"
 )
 
 func main() {
-	client := &http.Client{Timeout: 4 * time.Second}
-	resp, err := client.Get("http://localhost:8080/health")
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if os.Getenv("LINKDAVE_TLS_CERT_FILE") != "" {
+		scheme = "https"
+		// The certificate is issued for the public hostname, not localhost.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	client := &http.Client{Timeout: 4 * time.Second, Transport: transport}
+	resp, err := client.Get(scheme + "://localhost:8080/health")
 	if err != nil || resp.StatusCode != http.StatusNoContent {
 		os.Exit(1)
 	}
@@ -2,41 +2,165 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/shi-gg/linkdave/server/audio/source"
+	"github.com/shi-gg/linkdave/server/recording"
 	"github.com/shi-gg/linkdave/server/sentry"
 	"github.com/shi-gg/linkdave/server/server"
 	"github.com/shi-gg/linkdave/server/voice"
 )
 
-const DRAIN_TIMEOUT_SEC = 30
+const (
+	DRAIN_TIMEOUT_SEC     = 30
+	DEFAULT_OVERFLOW_WAIT = 100 * time.Millisecond
+)
 
 var (
-	version  = ""
-	password = os.Getenv("LINKDAVE_PASSWORD")
+	version     = ""
+	password    = os.Getenv("LINKDAVE_PASSWORD")
+	tlsCertFile = os.Getenv("LINKDAVE_TLS_CERT_FILE")
+	tlsKeyFile  = os.Getenv("LINKDAVE_TLS_KEY_FILE")
+
+	shutdownReportFile = os.Getenv("LINKDAVE_SHUTDOWN_REPORT_FILE")
 )
 
+// shutdownReport lets deploy tooling audit how many players were lost.
+type shutdownReport struct {
+	Reason          string   `json:"reason"`
+	PlayersAtDrain  int      `json:"players_at_drain"`
+	Migrated        int64    `json:"migrated"`
+	Forced          int      `json:"forced"`
+	DrainDurationMs int64    `json:"drain_duration_ms"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
 func main() {
 	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: getLogLevel()})
-	logger := slog.New(sentry.NewHandler(handler))
+	nodeName := getNodeName()
+	logger := slog.New(sentry.NewHandler(handler)).With(slog.String("node", nodeName))
 	slog.SetDefault(logger)
 
 	sentry.Init(os.Getenv("SENTRY_DSN"), version)
 	defer sentry.Flush(2 * time.Second)
 
 	logger.Info("starting linkdave", slog.String("version", version))
+
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		logger.Error("LINKDAVE_TLS_CERT_FILE and LINKDAVE_TLS_KEY_FILE must be set together")
+		os.Exit(1)
+	}
 	source.SetVersion(version)
 
+	opusVersion, err := source.CheckOpus()
+	if err != nil {
+		logger.Error("opus self-test failed, check the libopus installation", slog.Any("error", err))
+		os.Exit(1)
+	}
+	logger.Debug("opus self-test passed", slog.String("libopus", opusVersion))
+
+	proxy, err := source.ProxyURL()
+	if err != nil {
+		logger.Error("invalid source proxy", slog.Any("error", err))
+		os.Exit(1)
+	}
+	if proxy != nil {
+		// Only the host, the URL may carry credentials.
+		logger.Info("fetching sources through proxy", slog.String("proxy", proxy.Scheme+"://"+proxy.Host))
+	}
+
+	if cacheDir := os.Getenv("LINKDAVE_SOURCE_CACHE_DIR"); cacheDir != "" {
+		maxMB, _ := strconv.ParseInt(os.Getenv("LINKDAVE_SOURCE_CACHE_MAX_MB"), 10, 64)
+		if err := source.EnableCache(cacheDir, maxMB<<20); err != nil {
+			logger.Error("invalid source cache", slog.Any("error", err))
+			os.Exit(1)
+		}
+		logger.Info("caching sources on disk", slog.String("dir", cacheDir))
+	}
+
+	heartbeat, err := getHeartbeat()
+	if err != nil {
+		logger.Error("invalid websocket heartbeat", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	playerGrace, err := getEnvMs("LINKDAVE_PLAYER_GRACE_MS")
+	if err != nil {
+		logger.Error("invalid player grace", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	sendPolicy, err := getSendPolicy()
+	if err != nil {
+		logger.Error("invalid websocket send policy", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	trustedProxies, err := getTrustedProxies()
+	if err != nil {
+		logger.Error("invalid trusted proxies", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	originPolicy, err := getOriginPolicy()
+	if err != nil {
+		logger.Error("invalid websocket origin policy", slog.Any("error", err))
+		os.Exit(1)
+	}
+	logger.Info("checking websocket origins",
+		slog.String("mode", string(originPolicy.Mode)),
+		slog.Any("allowlist", originPolicy.Allowlist),
+	)
+
 	manager := voice.NewManager(logger)
+	if os.Getenv("LINKDAVE_PACING_STATS_ENABLED") == "true" {
+		manager.EnablePacingStats()
+	}
+	if threshold, err := strconv.Atoi(os.Getenv("LINKDAVE_VOICE_SEND_FAILURE_THRESHOLD")); err == nil {
+		manager.SetSendFailureThreshold(max(threshold, 0))
+	}
+	if underruns, err := strconv.Atoi(os.Getenv("LINKDAVE_REBUFFER_UNDERRUNS")); err == nil {
+		manager.SetRebufferUnderruns(max(underruns, 0))
+	}
+	if concurrency, err := strconv.Atoi(os.Getenv("LINKDAVE_VOICE_CONNECT_CONCURRENCY")); err == nil {
+		manager.SetConnectConcurrency(concurrency)
+	}
+	if recordingConfig := getRecordingConfig(); recordingConfig.Enabled() {
+		if err := os.MkdirAll(recordingConfig.Dir, 0o700); err != nil {
+			logger.Error("invalid recording directory", slog.Any("error", err))
+			os.Exit(1)
+		}
+		manager.SetRecording(recordingConfig)
+		logger.Warn("recording is enabled, admins can record voice connections", slog.String("dir", recordingConfig.Dir))
+	}
 
 	port := getPort()
 	server := server.NewServer(logger, manager, version, password)
+	server.SetHeartbeat(heartbeat)
+	server.SetSendPolicy(sendPolicy)
+	server.SetNodeName(nodeName)
+	server.SetTrustedProxies(trustedProxies)
+	server.SetOriginPolicy(originPolicy)
+	server.SetPlayerGrace(playerGrace)
+	if maxQueue, err := strconv.Atoi(os.Getenv("LINKDAVE_MAX_QUEUE_LENGTH")); err == nil {
+		server.SetMaxQueueLength(max(maxQueue, 0))
+	}
+	if maxQueueAdd, err := strconv.Atoi(os.Getenv("LINKDAVE_MAX_QUEUE_ADD_ITEMS")); err == nil {
+		server.SetMaxQueueAddItems(max(maxQueueAdd, 0))
+	}
+	if limitMB, err := strconv.ParseUint(os.Getenv("LINKDAVE_MEMORY_LIMIT_MB"), 10, 64); err == nil {
+		server.SetMemoryLimit(limitMB << 20)
+	}
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/ws", server.HandleWebSocket)
@@ -53,8 +177,8 @@ func main() {
 	errChan := make(chan error, 1)
 
 	go func() {
-		logger.Info("server listening", slog.String("addr", port))
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("server listening", slog.String("addr", port), slog.Bool("tls", tlsCertFile != ""))
+		if err := listen(httpServer); err != nil && err != http.ErrServerClosed {
 			errChan <- err
 		}
 	}()
@@ -62,13 +186,22 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	var report shutdownReport
+
 	select {
 	case sig := <-sigChan:
 		logger.Info("received shutdown signal", slog.String("signal", sig.String()))
+		report.Reason = sig.String()
 	case err := <-errChan:
 		logger.Error("server error", slog.Any("error", err))
+		report.Reason = "server error"
+		report.Errors = append(report.Errors, err.Error())
 	}
 
+	drainStart := time.Now()
+	report.PlayersAtDrain = server.PlayerCount()
+	migratedBefore := server.MigratedPlayers()
+
 	server.Drain("shutdown", int64(DRAIN_TIMEOUT_SEC))
 
 	drainCtx, drainCancel := context.WithTimeout(context.Background(), DRAIN_TIMEOUT_SEC*time.Second)
@@ -93,6 +226,10 @@ DrainLoop:
 		}
 	}
 
+	report.DrainDurationMs = time.Since(drainStart).Milliseconds()
+	report.Migrated = server.MigratedPlayers() - migratedBefore
+	report.Forced = server.PlayerCount()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -101,9 +238,31 @@ DrainLoop:
 
 	if err := httpServer.Shutdown(ctx); err != nil {
 		logger.Error("server shutdown error", slog.Any("error", err))
+		report.Errors = append(report.Errors, err.Error())
 	}
 
-	logger.Info("linkdave stopped")
+	logger.Info("linkdave stopped", slog.Any("report", report))
+
+	if shutdownReportFile != "" {
+		if err := writeShutdownReport(report); err != nil {
+			logger.Error("failed to write shutdown report", slog.Any("error", err))
+		}
+	}
+}
+
+func writeShutdownReport(report shutdownReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("marshal shutdown report: %w", err)
+	}
+	return os.WriteFile(shutdownReportFile, data, 0o644)
+}
+
+func listen(httpServer *http.Server) error {
+	if tlsCertFile != "" {
+		return httpServer.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+	}
+	return httpServer.ListenAndServe()
 }
 
 func getLogLevel() slog.Level {
@@ -119,6 +278,146 @@ func getLogLevel() slog.Level {
 	}
 }
 
+// getEnvMs returns 0 when key is unset. A typo or a negative value fails
+// startup, rather than quietly running with the default.
+func getEnvMs(key string) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	if ms < 0 {
+		return 0, fmt.Errorf("%s: must not be negative, got %d", key, ms)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func getHeartbeat() (server.Heartbeat, error) {
+	pongTimeout, err := getEnvMs("LINKDAVE_WS_PONG_TIMEOUT_MS")
+	if err != nil {
+		return server.Heartbeat{}, err
+	}
+	pingPeriod, err := getEnvMs("LINKDAVE_WS_PING_PERIOD_MS")
+	if err != nil {
+		return server.Heartbeat{}, err
+	}
+	initialTimeout, err := getEnvMs("LINKDAVE_WS_INITIAL_TIMEOUT_MS")
+	if err != nil {
+		return server.Heartbeat{}, err
+	}
+
+	heartbeat := server.DEFAULT_HEARTBEAT.WithPongTimeout(pongTimeout, pingPeriod)
+	if initialTimeout > 0 {
+		heartbeat.InitialTimeout = initialTimeout
+	}
+	if writeRate, err := strconv.Atoi(os.Getenv("LINKDAVE_WS_WRITE_RATE")); err == nil {
+		heartbeat.WriteRate = max(writeRate, 0)
+	}
+	return heartbeat, heartbeat.Validate()
+}
+
+// getTrustedProxies accepts CIDRs and single addresses.
+func getTrustedProxies() ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for entry := range strings.SplitSeq(os.Getenv("LINKDAVE_TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func getSendPolicy() (server.SendPolicy, error) {
+	policy := server.DEFAULT_SEND_POLICY
+	wait, err := getEnvMs("LINKDAVE_WS_OVERFLOW_WAIT_MS")
+	if err != nil {
+		return policy, err
+	}
+	if wait == 0 {
+		wait = DEFAULT_OVERFLOW_WAIT
+	}
+
+	for key, target := range map[string]*server.OverflowPolicy{
+		"LINKDAVE_WS_EVENT_OVERFLOW": &policy.Events,
+		"LINKDAVE_WS_STATE_OVERFLOW": &policy.State,
+	} {
+		target.Wait = wait
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+
+		mode, err := server.ParseOverflowMode(value)
+		if err != nil {
+			return policy, fmt.Errorf("%s: %w", key, err)
+		}
+		target.Mode = mode
+	}
+
+	return policy, nil
+}
+
+func getOriginPolicy() (server.OriginPolicy, error) {
+	policy := server.DEFAULT_ORIGIN_POLICY
+	for entry := range strings.SplitSeq(os.Getenv("LINKDAVE_WS_ORIGIN_ALLOWLIST"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			policy.Allowlist = append(policy.Allowlist, entry)
+		}
+	}
+
+	if value := os.Getenv("LINKDAVE_WS_ORIGIN_MODE"); value != "" {
+		mode, err := server.ParseOriginMode(value)
+		if err != nil {
+			return policy, err
+		}
+		policy.Mode = mode
+	}
+
+	return policy, policy.Validate()
+}
+
+func getRecordingConfig() recording.Config {
+	config := recording.Config{Dir: os.Getenv("LINKDAVE_RECORDING_DIR")}
+	if mb, err := strconv.ParseInt(os.Getenv("LINKDAVE_RECORDING_ROTATE_MB"), 10, 64); err == nil {
+		config.RotateBytes = mb << 20
+	}
+	if sec, err := strconv.ParseInt(os.Getenv("LINKDAVE_RECORDING_ROTATE_SEC"), 10, 64); err == nil {
+		config.RotateDuration = time.Duration(sec) * time.Second
+	}
+	return config
+}
+
+// The hostname is unique per container, which is usually enough to tell nodes
+// apart without configuring anything.
+func getNodeName() string {
+	if name := os.Getenv("LINKDAVE_NODE_NAME"); name != "" {
+		return name
+	}
+
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
 func getPort() string {
 	port := os.Getenv("LINKDAVE_PORT")
 	if port != "" {
@@ -0,0 +1,68 @@
+package filter
+
+import "math"
+
+const (
+	// Kept narrow on purpose: the AGC should only even out a stream's level
+	// over time, not compress away the dynamics of the music itself.
+	AGC_MAX_GAIN = 2.0
+	AGC_MIN_GAIN = 0.5
+
+	// Below roughly -50 dBFS the signal is treated as silence and the gain is
+	// held, otherwise quiet passages would be pumped up.
+	AGC_NOISE_FLOOR = 100.0
+)
+
+type AGC struct {
+	target     float64
+	attackMs   float64
+	releaseMs  float64
+	sampleRate float64
+
+	level float64
+	gain  float64
+}
+
+func NewAGC(sampleRate, targetDB, attackMs, releaseMs float64) *AGC {
+	return &AGC{
+		target:     32768 * math.Pow(10, targetDB/20),
+		attackMs:   max(attackMs, 1),
+		releaseMs:  max(releaseMs, 1),
+		sampleRate: sampleRate,
+		level:      32768 * math.Pow(10, targetDB/20),
+		gain:       1.0,
+	}
+}
+
+func (a *AGC) Process(samples []int16) {
+	if len(samples) == 0 {
+		return
+	}
+
+	var sumSquares float64
+	for _, s := range samples {
+		v := float64(s)
+		sumSquares += v * v
+	}
+	rms := math.Sqrt(sumSquares / float64(len(samples)))
+
+	timeConstant := a.releaseMs
+	if rms > a.level {
+		timeConstant = a.attackMs
+	}
+	frameMs := float64(len(samples)/2) / a.sampleRate * 1000
+	a.level += (rms - a.level) * (1 - math.Exp(-frameMs/timeConstant))
+
+	targetGain := a.gain
+	if a.level > AGC_NOISE_FLOOR {
+		targetGain = min(max(a.target/a.level, AGC_MIN_GAIN), AGC_MAX_GAIN)
+	}
+
+	// Ramp across the frame so gain changes never land as a step.
+	step := (targetGain - a.gain) / float64(len(samples))
+	for i, s := range samples {
+		a.gain += step
+		samples[i] = clampInt16(float64(s) * a.gain)
+	}
+	a.gain = targetGain
+}
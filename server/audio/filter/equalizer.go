@@ -0,0 +1,109 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	EQUALIZER_BAND_COUNT  = 10
+	EQUALIZER_MAX_GAIN_DB = 12.0
+
+	// Roughly one octave per band, matching the spacing of EQUALIZER_FREQUENCIES.
+	EQUALIZER_Q = 1.41
+)
+
+var EQUALIZER_FREQUENCIES = [EQUALIZER_BAND_COUNT]float64{31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+var EQUALIZER_PRESETS = map[string][EQUALIZER_BAND_COUNT]float64{
+	"flat":        {},
+	"bassboost":   {6, 5, 4, 2, 0, 0, 0, 0, 0, 0},
+	"trebleboost": {0, 0, 0, 0, 0, 0, 2, 4, 5, 6},
+	"vocal":       {-2, -2, -1, 0, 2, 4, 4, 2, 0, -1},
+}
+
+type EqualizerBand struct {
+	Band int     `json:"band"`
+	Gain float64 `json:"gain"`
+}
+
+func (f *Filters) validateEqualizer() error {
+	if _, ok := EQUALIZER_PRESETS[f.EqualizerPreset]; f.EqualizerPreset != "" && !ok {
+		return fmt.Errorf("unknown equalizer preset: %s", f.EqualizerPreset)
+	}
+	if len(f.Equalizer) > EQUALIZER_BAND_COUNT {
+		return fmt.Errorf("too many equalizer bands: %d, at most %d", len(f.Equalizer), EQUALIZER_BAND_COUNT)
+	}
+
+	for _, b := range f.Equalizer {
+		if b.Band < 0 || b.Band >= EQUALIZER_BAND_COUNT {
+			return fmt.Errorf("equalizer band out of range: %d", b.Band)
+		}
+		if math.Abs(b.Gain) > EQUALIZER_MAX_GAIN_DB {
+			return fmt.Errorf("equalizer gain out of range for band %d: %g", b.Band, b.Gain)
+		}
+	}
+
+	return nil
+}
+
+// Explicit bands are applied on top of the preset so clients can tweak a preset
+// without shipping the whole table.
+func (f *Filters) resolvedEqualizer() [EQUALIZER_BAND_COUNT]float64 {
+	gains := EQUALIZER_PRESETS[f.EqualizerPreset]
+	for _, b := range f.Equalizer {
+		gains[b.Band] = b.Gain
+	}
+	return gains
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+
+	x1, x2, y1, y2 [2]float64
+}
+
+func newPeakingBiquad(freq, gainDB, sampleRate float64) *biquad {
+	a := math.Pow(10, gainDB/40)
+	w0 := 2 * math.Pi * freq / sampleRate
+	alpha := math.Sin(w0) / (2 * EQUALIZER_Q)
+	cosW0 := math.Cos(w0)
+
+	a0 := 1 + alpha/a
+	return &biquad{
+		b0: (1 + alpha*a) / a0,
+		b1: (-2 * cosW0) / a0,
+		b2: (1 - alpha*a) / a0,
+		a1: (-2 * cosW0) / a0,
+		a2: (1 - alpha/a) / a0,
+	}
+}
+
+func (b *biquad) process(x float64, ch int) float64 {
+	y := b.b0*x + b.b1*b.x1[ch] + b.b2*b.x2[ch] - b.a1*b.y1[ch] - b.a2*b.y2[ch]
+	b.x2[ch], b.x1[ch] = b.x1[ch], x
+	b.y2[ch], b.y1[ch] = b.y1[ch], y
+	return y
+}
+
+func newEqualizer(gains [EQUALIZER_BAND_COUNT]float64, sampleRate float64) []*biquad {
+	var bands []*biquad
+	for i, gain := range gains {
+		if gain == 0 || EQUALIZER_FREQUENCIES[i] >= sampleRate/2 {
+			continue
+		}
+		bands = append(bands, newPeakingBiquad(EQUALIZER_FREQUENCIES[i], gain, sampleRate))
+	}
+	return bands
+}
+
+func (p *Processor) processEqualizer(samples []int16) {
+	for i, s := range samples {
+		ch := i % 2
+		v := float64(s)
+		for _, band := range p.equalizer {
+			v = band.process(v, ch)
+		}
+		samples[i] = clampInt16(v)
+	}
+}
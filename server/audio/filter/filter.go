@@ -17,10 +17,28 @@ const (
 	typeMax
 )
 
+var TYPE_NAMES = [typeMax]string{
+	Vaporwave: "vaporwave",
+	Nightcore: "nightcore",
+	Rotation:  "rotation",
+	Tremolo:   "tremolo",
+	Vibrato:   "vibrato",
+	LowPass:   "lowpass",
+}
+
+// Available lists every filter a node can apply, including the parametric ones
+// that aren't toggled through Enabled.
+func Available() []string {
+	return append(TYPE_NAMES[:], "pitch", "speed", "equalizer")
+}
+
 type Filters struct {
 	Enabled []Type  `json:"enabled,omitempty"`
 	Pitch   float64 `json:"pitch,omitempty"`
 	Speed   float64 `json:"speed,omitempty"`
+
+	EqualizerPreset string          `json:"eq_preset,omitempty"`
+	Equalizer       []EqualizerBand `json:"equalizer,omitempty"`
 }
 
 func (f *Filters) resolvedTimescale() (speed, pitch float64) {
@@ -48,6 +66,16 @@ func (f *Filters) resolvedTimescale() (speed, pitch float64) {
 	return speed, pitch
 }
 
+// PlaybackSpeed is how many milliseconds of the track play per millisecond of
+// wall time with these filters.
+func (f *Filters) PlaybackSpeed() float64 {
+	if f == nil {
+		return 1.0
+	}
+	speed, _ := f.resolvedTimescale()
+	return speed
+}
+
 func (f *Filters) hasFilter(ft Type) bool {
 	for _, t := range f.Enabled {
 		if t == ft {
@@ -58,7 +86,7 @@ func (f *Filters) hasFilter(ft Type) bool {
 }
 
 func (f *Filters) IsEmpty() bool {
-	return f == nil || (len(f.Enabled) == 0 && f.Pitch <= 0 && f.Speed <= 0)
+	return f == nil || (len(f.Enabled) == 0 && f.Pitch <= 0 && f.Speed <= 0 && f.EqualizerPreset == "" && len(f.Equalizer) == 0)
 }
 
 func (f *Filters) Validate() error {
@@ -70,7 +98,7 @@ func (f *Filters) Validate() error {
 			return fmt.Errorf("unknown filter type: %d", ft)
 		}
 	}
-	return nil
+	return f.validateEqualizer()
 }
 
 func (f *Filters) Normalize() *Filters {
@@ -95,19 +123,22 @@ type Processor struct {
 
 	vibratoBuf []int16
 
+	equalizer []*biquad
+
 	sampleRate float64
 }
 
-func NewProcessor(filters *Filters, sampleRate float64) *Processor {
+func NewProcessor(filters *Filters, sampleRate float64, frameSize int) *Processor {
 	speed, pitch := filters.resolvedTimescale()
 	p := &Processor{
 		filters:    filters,
 		speed:      speed,
 		pitch:      pitch,
 		sampleRate: sampleRate,
+		equalizer:  newEqualizer(filters.resolvedEqualizer(), sampleRate),
 	}
 	if filters.hasFilter(Vibrato) {
-		p.vibratoBuf = make([]int16, 960*2)
+		p.vibratoBuf = make([]int16, frameSize*2)
 	}
 	return p
 }
@@ -123,6 +154,10 @@ func (p *Processor) PitchRatio() float64 {
 func (p *Processor) Process(samples []int16) {
 	n := len(samples) / 2
 
+	if len(p.equalizer) > 0 {
+		p.processEqualizer(samples)
+	}
+
 	if p.filters.hasFilter(Tremolo) {
 		const freq = 4.0
 		const depth = 0.6
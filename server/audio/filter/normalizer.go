@@ -0,0 +1,62 @@
+package filter
+
+import "math"
+
+const (
+	// -18 dBFS RMS leaves enough headroom for peaks on typical mastered music.
+	NORMALIZE_TARGET_RMS = 4125.0
+	NORMALIZE_MAX_GAIN   = 2.0
+	NORMALIZE_MIN_GAIN   = 0.25
+
+	// Loudness is measured over the start of the track and then held, so the
+	// track's own dynamics are preserved instead of being flattened.
+	NORMALIZE_ANALYSIS_SECONDS = 10.0
+	NORMALIZE_WARMUP_SECONDS   = 0.5
+	NORMALIZE_SMOOTHING        = 0.0005
+)
+
+type Normalizer struct {
+	analysisSamples int
+	warmupSamples   int
+
+	sumSquares float64
+	count      int
+
+	gain       float64
+	targetGain float64
+}
+
+func NewNormalizer(sampleRate float64) *Normalizer {
+	return &Normalizer{
+		analysisSamples: int(NORMALIZE_ANALYSIS_SECONDS * sampleRate * 2),
+		warmupSamples:   int(NORMALIZE_WARMUP_SECONDS * sampleRate * 2),
+		gain:            1.0,
+		targetGain:      1.0,
+	}
+}
+
+func (n *Normalizer) Process(samples []int16) {
+	if n.count < n.analysisSamples {
+		n.measure(samples)
+	}
+
+	for i, s := range samples {
+		n.gain += (n.targetGain - n.gain) * NORMALIZE_SMOOTHING
+		samples[i] = clampInt16(float64(s) * n.gain)
+	}
+}
+
+func (n *Normalizer) measure(samples []int16) {
+	for _, s := range samples {
+		v := float64(s)
+		n.sumSquares += v * v
+	}
+	n.count += len(samples)
+
+	if n.count < n.warmupSamples || n.sumSquares == 0 {
+		return
+	}
+
+	rms := math.Sqrt(n.sumSquares / float64(n.count))
+	n.targetGain = min(max(NORMALIZE_TARGET_RMS/rms, NORMALIZE_MIN_GAIN), NORMALIZE_MAX_GAIN)
+}
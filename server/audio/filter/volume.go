@@ -0,0 +1,91 @@
+package filter
+
+import "fmt"
+
+const (
+	DEFAULT_VOLUME = 100
+	// Matches lavalink, anything louder just clips.
+	MAX_VOLUME = 1000
+)
+
+func ValidateVolume(volume int) error {
+	if volume < 0 || volume > MAX_VOLUME {
+		return fmt.Errorf("volume must be between 0 and %d", MAX_VOLUME)
+	}
+	return nil
+}
+
+// ApplyVolume scales the samples by volume percent, clamping instead of
+// wrapping around so boosted peaks don't turn into loud cracks.
+func ApplyVolume(samples []int16, volume int) {
+	if volume == DEFAULT_VOLUME {
+		return
+	}
+
+	for i, sample := range samples {
+		scaled := int32(sample) * int32(volume) / DEFAULT_VOLUME
+		samples[i] = int16(max(min(scaled, 32767), -32768))
+	}
+}
+
+// Longest volume ramp, fades longer than this are better done by the client.
+const MAX_VOLUME_RAMP_MS = 10_000
+
+func ValidateVolumeRamp(rampMs int) error {
+	if rampMs < 0 || rampMs > MAX_VOLUME_RAMP_MS {
+		return fmt.Errorf("volume_ramp_ms must be between 0 and %d", MAX_VOLUME_RAMP_MS)
+	}
+	return nil
+}
+
+// VolumeRamp applies the volume, gliding to a new one instead of jumping,
+// which clicks on big changes. Not safe for concurrent use.
+type VolumeRamp struct {
+	sampleRate float64
+	current    float64
+	target     float64
+	// Change per sample frame, positive or negative.
+	step float64
+	// Sample frames until the target is reached.
+	remaining int
+}
+
+func NewVolumeRamp(sampleRate float64, volume int) *VolumeRamp {
+	return &VolumeRamp{sampleRate: sampleRate, current: float64(volume), target: float64(volume)}
+}
+
+// Set glides to volume over rampMs, 0 changes it right away.
+func (r *VolumeRamp) Set(volume, rampMs int) {
+	r.target = float64(volume)
+	r.remaining = int(float64(rampMs) * r.sampleRate / 1000)
+	if r.remaining == 0 {
+		r.current = r.target
+		return
+	}
+	r.step = (r.target - r.current) / float64(r.remaining)
+}
+
+// Process scales interleaved samples with the given channel count.
+func (r *VolumeRamp) Process(samples []int16, channels int) {
+	if r.remaining == 0 {
+		ApplyVolume(samples, int(r.target))
+		return
+	}
+
+	for i := 0; i+channels <= len(samples); i += channels {
+		if r.remaining > 0 {
+			r.remaining--
+			r.current += r.step
+			if r.remaining == 0 {
+				// Rounding errors of the steps must not linger.
+				r.current = r.target
+			}
+		}
+
+		gain := r.current / DEFAULT_VOLUME
+		for c := range channels {
+			scaled := float64(samples[i+c]) * gain
+			samples[i+c] = int16(max(min(scaled, 32767), -32768))
+		}
+	}
+}
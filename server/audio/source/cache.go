@@ -0,0 +1,442 @@
+package source
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_CACHE_MAX_BYTES = 1 << 30
+
+	CACHE_DATA_EXT = ".audio"
+	CACHE_META_EXT = ".json"
+	CACHE_TEMP_EXT = ".tmp"
+)
+
+// Nil unless EnableCache was called.
+var sourceCache atomic.Pointer[Cache]
+
+// Cache keeps complete downloads of range capable sources on disk, so tracks
+// that are played over and over, like jingles, are fetched once. Entries are
+// keyed by URL, revalidated with their ETag or Last-Modified once stale, and
+// evicted least recently used first.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	// Most recently used at the front, values are *cacheEntry.
+	lru  *list.List
+	size int64
+}
+
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	Size         int64     `json:"size"`
+	Expires      time.Time `json:"expires"`
+}
+
+// EnableCache must be called before any source is created.
+func EnableCache(dir string, maxBytes int64) error {
+	if maxBytes <= 0 {
+		maxBytes = DEFAULT_CACHE_MAX_BYTES
+	}
+
+	cache := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+	if err := cache.load(); err != nil {
+		return fmt.Errorf("load source cache: %w", err)
+	}
+
+	sourceCache.Store(cache)
+	return nil
+}
+
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key, ext string) string {
+	return filepath.Join(c.dir, key+ext)
+}
+
+// load restores the index, a metadata file's modification time is when the
+// entry was last used.
+func (c *Cache) load() error {
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type loaded struct {
+		entry *cacheEntry
+		used  time.Time
+	}
+	var entries []loaded
+
+	for _, file := range files {
+		name := file.Name()
+		switch filepath.Ext(name) {
+		case CACHE_TEMP_EXT:
+			// Left behind by a download that was interrupted by a restart.
+			os.Remove(filepath.Join(c.dir, name))
+		case CACHE_META_EXT:
+			key := strings.TrimSuffix(name, CACHE_META_EXT)
+			entry, used, err := c.readEntry(key)
+			if err != nil {
+				slog.Warn("dropping broken source cache entry", slog.String("key", key), slog.Any("error", err))
+				c.removeFiles(key)
+				continue
+			}
+			entries = append(entries, loaded{entry: entry, used: used})
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b loaded) int {
+		return a.used.Compare(b.used)
+	})
+	for _, l := range entries {
+		c.entries[cacheKey(l.entry.URL)] = c.lru.PushFront(l.entry)
+		c.size += l.entry.Size
+	}
+
+	c.mutex.Lock()
+	c.evict()
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *Cache) readEntry(key string) (*cacheEntry, time.Time, error) {
+	metaPath := c.path(key, CACHE_META_EXT)
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, time.Time{}, err
+	}
+	if cacheKey(entry.URL) != key {
+		return nil, time.Time{}, fmt.Errorf("entry doesn't match its key")
+	}
+
+	info, err := os.Stat(c.path(key, CACHE_DATA_EXT))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if info.Size() != entry.Size {
+		return nil, time.Time{}, fmt.Errorf("data is %d bytes, expected %d", info.Size(), entry.Size)
+	}
+
+	meta, err := os.Stat(metaPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &entry, meta.ModTime(), nil
+}
+
+// lookup returns a copy of the entry for url, as entries are replaced while
+// callers still use them.
+func (c *Cache) lookup(url string) *cacheEntry {
+	key := cacheKey(url)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+
+	now := time.Now()
+	os.Chtimes(c.path(key, CACHE_META_EXT), now, now)
+
+	entry := *el.Value.(*cacheEntry)
+	return &entry
+}
+
+func (e *cacheEntry) fresh() bool {
+	return time.Now().Before(e.Expires)
+}
+
+func (e *cacheEntry) setValidators(header http.Header) {
+	if e.ETag != "" {
+		header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+func (c *Cache) open(entry *cacheEntry) (*origin, error) {
+	dataPath := c.path(cacheKey(entry.URL), CACHE_DATA_EXT)
+	file, err := os.Open(dataPath)
+	if err != nil {
+		c.remove(entry.URL)
+		return nil, fmt.Errorf("open cached audio: %w", err)
+	}
+
+	return &origin{
+		body:          file,
+		contentLength: entry.Size,
+		contentType:   entry.ContentType,
+		cached:        true,
+		openAt: func(_ context.Context, offset int64) (io.ReadCloser, error) {
+			file, err := os.Open(dataPath)
+			if err != nil {
+				return nil, fmt.Errorf("open cached audio: %w", err)
+			}
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("seek cached audio: %w", err)
+			}
+			return file, nil
+		},
+	}, nil
+}
+
+// revalidated extends a stale entry the origin confirmed as unchanged. The
+// file is opened first, so an entry whose data went missing is dropped rather
+// than extended.
+func (c *Cache) revalidated(entry *cacheEntry, header http.Header) (*origin, error) {
+	org, err := c.open(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	expires, _ := cacheExpiry(header, time.Now())
+	entry.Expires = expires
+
+	c.mutex.Lock()
+	if el, ok := c.entries[cacheKey(entry.URL)]; ok {
+		el.Value.(*cacheEntry).Expires = expires
+	}
+	c.mutex.Unlock()
+
+	if err := c.writeMeta(entry); err != nil {
+		slog.Warn("failed to update source cache entry", slog.Any("error", err))
+	}
+	return org, nil
+}
+
+// store passes body through while copying it to disk, the copy becomes an
+// entry once the whole response was read. Responses that could never be
+// reused are not copied.
+func (c *Cache) store(url string, resp *http.Response, body io.ReadCloser) io.ReadCloser {
+	now := time.Now()
+	expires, cacheable := cacheExpiry(resp.Header, now)
+	entry := cacheEntry{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+		Size:         resp.ContentLength,
+		Expires:      expires,
+	}
+
+	if resp.StatusCode != http.StatusOK || !cacheable || entry.Size <= 0 || entry.Size > c.maxBytes {
+		return body
+	}
+	if entry.ETag == "" && entry.LastModified == "" && !expires.After(now) {
+		return body
+	}
+
+	file, err := os.CreateTemp(c.dir, "*"+CACHE_TEMP_EXT)
+	if err != nil {
+		slog.Warn("failed to create source cache file", slog.Any("error", err))
+		return body
+	}
+
+	return &cacheWriter{ReadCloser: body, cache: c, file: file, entry: entry}
+}
+
+func (c *Cache) commit(file *os.File, entry cacheEntry) error {
+	key := cacheKey(entry.URL)
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), c.path(key, CACHE_DATA_EXT)); err != nil {
+		return err
+	}
+	if err := c.writeMeta(&entry); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).Size
+		c.lru.Remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&entry)
+	c.size += entry.Size
+	c.evict()
+	return nil
+}
+
+func (c *Cache) writeMeta(entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path(cacheKey(entry.URL), CACHE_META_EXT), data, 0o600)
+}
+
+func (c *Cache) remove(url string) {
+	key := cacheKey(url)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).Size
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+	c.removeFiles(key)
+}
+
+// evict is called with the mutex held. Sources still reading an evicted file
+// keep their open handle.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		entry := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		key := cacheKey(entry.URL)
+		delete(c.entries, key)
+		c.size -= entry.Size
+		c.removeFiles(key)
+	}
+}
+
+func (c *Cache) removeFiles(key string) {
+	os.Remove(c.path(key, CACHE_META_EXT))
+	os.Remove(c.path(key, CACHE_DATA_EXT))
+}
+
+// cacheExpiry reports until when a response is fresh and whether it may be
+// stored at all. Without explicit freshness, it has to be revalidated before
+// every reuse.
+func cacheExpiry(header http.Header, now time.Time) (time.Time, bool) {
+	noCache := false
+	maxAge := -1
+	for directive := range strings.SplitSeq(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store":
+			return now, false
+		case "no-cache":
+			noCache = true
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				maxAge = seconds
+			}
+		}
+	}
+
+	switch {
+	case noCache:
+		return now, true
+	case maxAge >= 0:
+		return now.Add(time.Duration(maxAge) * time.Second), true
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		return expires, true
+	}
+	return now, true
+}
+
+// cacheWriter commits the copy only when the response was read to its end
+// with the announced length, a track that was skipped or seeked away from is
+// discarded.
+type cacheWriter struct {
+	io.ReadCloser
+	cache *Cache
+	entry cacheEntry
+
+	// Not held while reading, as Close is used to interrupt a blocked Read.
+	mutex   sync.Mutex
+	file    *os.File
+	written int64
+}
+
+func (w *cacheWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		if _, writeErr := w.file.Write(p[:n]); writeErr != nil {
+			slog.Warn("failed to write source cache file", slog.Any("error", writeErr))
+			w.discard()
+			return n, err
+		}
+		w.written += int64(n)
+	}
+
+	if err == io.EOF {
+		if w.written != w.entry.Size {
+			w.discard()
+			return n, err
+		}
+		if commitErr := w.cache.commit(w.file, w.entry); commitErr != nil {
+			slog.Warn("failed to store source cache entry", slog.Any("error", commitErr))
+			os.Remove(w.file.Name())
+		}
+		w.file = nil
+	}
+
+	return n, err
+}
+
+func (w *cacheWriter) Close() error {
+	w.mutex.Lock()
+	if w.file != nil {
+		w.discard()
+	}
+	w.mutex.Unlock()
+
+	return w.ReadCloser.Close()
+}
+
+// discard is called with the mutex held.
+func (w *cacheWriter) discard() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+	w.file = nil
+}
@@ -3,6 +3,8 @@ package source
 import (
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 type Config struct {
@@ -10,33 +12,97 @@ type Config struct {
 	HTTPSEnabled            bool
 	PublicIPAddressEnabled  bool
 	PrivateIPAddressEnabled bool
+	HostAllowlist           []string
+	HostDenylist            []string
 	TextToSpeechEnabled     bool
 	TextToSpeechURL         string
 	TextToSpeechToken       string
 	UserAgent               string
+	ProxyURL                string
+	NormalizationEnabled    bool
+	OpusMono                bool
+	OpusDTX                 bool
+	OpusBitrate             int
+	OpusComplexity          int
+	OpusReuseEncoders       bool
+	AGCEnabled              bool
+	AGCTargetDB             float64
+	AGCAttackMs             float64
+	AGCReleaseMs            float64
+	MaxConcurrentCreates    int
+	DataURLEnabled          bool
+	// Decoded size limit of data URLs.
+	DataURLMaxBytes int
+
+	// Extra attempts at getting a response from an origin, see doWithRetry.
+	ConnectRetries   int
+	ConnectBackoffMs int
+
+	// How many milliseconds of the compressed stream are downloaded ahead of
+	// playback, 0 reads straight from the connection.
+	BufferMs int
 }
 
-var cfg Config
+var (
+	config   Config
+	configMu sync.RWMutex
+)
 
 func init() {
-	cfg = Config{
+	config = Config{
 		HTTPEnabled:             getEnvBool("LINKDAVE_SOURCE_HTTP_ENABLED", false),
 		HTTPSEnabled:            getEnvBool("LINKDAVE_SOURCE_HTTPS_ENABLED", false),
 		PublicIPAddressEnabled:  getEnvBool("LINKDAVE_SOURCE_IP_ADDRESS_PUBLIC_ENABLED", false),
 		PrivateIPAddressEnabled: getEnvBool("LINKDAVE_SOURCE_IP_ADDRESS_PRIVATE_ENABLED", false),
+		HostAllowlist:           getEnvList("LINKDAVE_SOURCE_HOST_ALLOWLIST"),
+		HostDenylist:            getEnvList("LINKDAVE_SOURCE_HOST_DENYLIST"),
 		TextToSpeechEnabled:     getEnvBool("LINKDAVE_SOURCE_TEXT_TO_SPEECH_ENABLED", false),
 		TextToSpeechURL:         getEnvString("LINKDAVE_SOURCE_TEXT_TO_SPEECH_URL", "https://tts.wamellow.com/api/invoke"),
 		TextToSpeechToken:       getEnvString("LINKDAVE_SOURCE_TEXT_TO_SPEECH_TOKEN", ""),
 		UserAgent:               "Linkdave/v0.0.0",
+		ProxyURL:                getEnvString("LINKDAVE_SOURCE_PROXY_URL", ""),
+		NormalizationEnabled:    getEnvBool("LINKDAVE_NORMALIZATION_ENABLED", false),
+		OpusMono:                getEnvBool("LINKDAVE_OPUS_MONO", false),
+		OpusDTX:                 getEnvBool("LINKDAVE_OPUS_DTX", false),
+		OpusBitrate:             getEnvBitrate("LINKDAVE_OPUS_BITRATE"),
+		OpusComplexity:          getEnvComplexity("LINKDAVE_OPUS_COMPLEXITY"),
+		OpusReuseEncoders:       getEnvBool("LINKDAVE_OPUS_REUSE_ENCODERS", true),
+		AGCEnabled:              getEnvBool("LINKDAVE_AGC_ENABLED", false),
+		AGCTargetDB:             getEnvFloat("LINKDAVE_AGC_TARGET_DB", -18),
+		AGCAttackMs:             getEnvFloat("LINKDAVE_AGC_ATTACK_MS", 1000),
+		AGCReleaseMs:            getEnvFloat("LINKDAVE_AGC_RELEASE_MS", 5000),
+		MaxConcurrentCreates:    getEnvInt("LINKDAVE_SOURCE_MAX_CONCURRENT_CREATES", 32),
+		ConnectRetries:          max(getEnvInt("LINKDAVE_SOURCE_CONNECT_RETRIES", DEFAULT_CONNECT_RETRIES), 0),
+		ConnectBackoffMs:        max(getEnvInt("LINKDAVE_SOURCE_CONNECT_BACKOFF_MS", DEFAULT_CONNECT_BACKOFF_MS), 0),
+		DataURLEnabled:          getEnvBool("LINKDAVE_SOURCE_DATA_URL_ENABLED", false),
+		DataURLMaxBytes:         getEnvInt("LINKDAVE_SOURCE_DATA_URL_MAX_BYTES", DEFAULT_DATA_URL_MAX_BYTES),
+		BufferMs:                min(max(getEnvInt("LINKDAVE_SOURCE_BUFFER_MS", 0), 0), MAX_BUFFER_MS),
 	}
+
+	createSlots = make(chan struct{}, max(config.MaxConcurrentCreates, 1))
 }
 
 func SetVersion(v string) {
-	cfg.UserAgent = "Linkdave/" + v
+	UpdateConfig(func(c *Config) {
+		c.UserAgent = "Linkdave/" + v
+	})
 }
 
+// GetConfig returns a snapshot, so callers should read it once per operation
+// to see a consistent config even while it is being updated.
 func GetConfig() Config {
-	return cfg
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// UpdateConfig only affects sources created afterwards, playing tracks keep
+// the config they were started with.
+func UpdateConfig(update func(c *Config)) Config {
+	configMu.Lock()
+	defer configMu.Unlock()
+	update(&config)
+	return config
 }
 
 func getEnvBool(key string, defaultValue bool) bool {
@@ -51,6 +117,58 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return b
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return defaultValue
+	}
+	return i
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return f
+}
+
+// Invalid bitrates fall back to letting libopus choose.
+func getEnvBitrate(key string) int {
+	bitrate := getEnvInt(key, 0)
+	if ValidateBitrate(bitrate) != nil {
+		return 0
+	}
+	return bitrate
+}
+
+// Invalid complexities fall back to the default.
+func getEnvComplexity(key string) int {
+	complexity := getEnvInt(key, DEFAULT_OPUS_COMPLEXITY)
+	if ValidateComplexity(complexity) != nil {
+		return DEFAULT_OPUS_COMPLEXITY
+	}
+	return complexity
+}
+
+func getEnvList(key string) []string {
+	var list []string
+	for item := range strings.SplitSeq(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, strings.ToLower(item))
+		}
+	}
+	return list
+}
+
 func getEnvString(key string, defaultValue string) string {
 	val := os.Getenv(key)
 	if val == "" {
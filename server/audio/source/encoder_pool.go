@@ -0,0 +1,73 @@
+package source
+
+import (
+	"sync"
+
+	"github.com/hraban/opus"
+)
+
+// Enough for the old and the new track of a change, which overlap while the
+// new one loads.
+const MAX_POOLED_ENCODERS = 2
+
+// EncoderPool keeps the opus encoders of finished tracks for the next ones,
+// so a player doesn't create an encoder per track. A nil pool always creates
+// a new one.
+type EncoderPool struct {
+	mutex sync.Mutex
+	free  map[encoderKey][]*opus.Encoder
+}
+
+// encoderKey is what an encoder is created with and can't change afterwards.
+type encoderKey struct {
+	channels    int
+	application opus.Application
+}
+
+func NewEncoderPool() *EncoderPool {
+	return &EncoderPool{free: make(map[encoderKey][]*opus.Encoder)}
+}
+
+// get resets a pooled encoder, which drops the audio state the last track
+// left in it. Its settings survive the reset, callers have to set them all.
+func (p *EncoderPool) get(key encoderKey) (*opus.Encoder, error) {
+	enc := p.pop(key)
+	if enc == nil {
+		return opus.NewEncoder(OPUS_SAMPLE_RATE, key.channels, key.application)
+	}
+	if err := enc.Reset(); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+func (p *EncoderPool) pop(key encoderKey) *opus.Encoder {
+	if p == nil {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	free := p.free[key]
+	n := len(free)
+	if n == 0 {
+		return nil
+	}
+	p.free[key] = free[:n-1]
+	return free[n-1]
+}
+
+// put must only be called once nothing encodes with enc anymore.
+func (p *EncoderPool) put(key encoderKey, enc *opus.Encoder) {
+	if p == nil || enc == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.free[key]) < MAX_POOLED_ENCODERS {
+		p.free[key] = append(p.free[key], enc)
+	}
+}
@@ -0,0 +1,47 @@
+package source
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type decompressedReadCloser struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (r *decompressedReadCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.body.Close()
+}
+
+// decompressBody honors Content-Encoding for origins that compress audio even
+// though it was not asked for. The transport already decompresses gzip it
+// requested itself, which is reported by resp.Uncompressed.
+func decompressBody(resp *http.Response) (body io.ReadCloser, compressed bool, err error) {
+	if resp.Uncompressed {
+		return resp.Body, true, nil
+	}
+
+	var reader io.ReadCloser
+	switch encoding := strings.ToLower(resp.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+		return resp.Body, false, nil
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		// HTTP deflate is zlib wrapped, not raw deflate.
+		reader, err = zlib.NewReader(resp.Body)
+	default:
+		return nil, false, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("create decompressor: %w", err)
+	}
+
+	return &decompressedReadCloser{ReadCloser: reader, body: resp.Body}, true, nil
+}
@@ -0,0 +1,95 @@
+package source
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	MP4_BOX_TYPE_OFFSET = 4
+	ADTS_HEADER_SIZE    = 7
+)
+
+var (
+	tagFtyp = []byte("ftyp")
+
+	// Only MP3 can be decoded, these are refused up front instead of being fed
+	// to the MP3 decoder, which would skip through the whole file looking for
+	// frames and report an empty stream.
+	UNSUPPORTED_CONTENT_TYPES = map[string]string{
+		"audio/aac":       "aac",
+		"audio/aacp":      "aac",
+		"audio/x-aac":     "aac",
+		"audio/mp4":       "mp4",
+		"audio/x-m4a":     "mp4",
+		"video/mp4":       "mp4",
+		"audio/ogg":       "ogg",
+		"audio/opus":      "ogg",
+		"application/ogg": "ogg",
+		"audio/flac":      "flac",
+		"audio/x-flac":    "flac",
+		"audio/wav":       "wav",
+		"audio/wave":      "wav",
+		"audio/x-wav":     "wav",
+		"audio/webm":      "webm",
+		"video/webm":      "webm",
+	}
+
+	// Checked against the start of the stream, for servers that send a
+	// generic content type.
+	UNSUPPORTED_MAGIC = []struct {
+		offset int
+		magic  []byte
+		format string
+	}{
+		{0, []byte("OggS"), "ogg"},
+		{0, []byte("fLaC"), "flac"},
+		{8, []byte("WAVE"), "wav"},
+		{8, []byte("AIFF"), "aiff"},
+		{0, []byte{0x1A, 0x45, 0xDF, 0xA3}, "webm"},
+		{MP4_BOX_TYPE_OFFSET, tagFtyp, "mp4"},
+	}
+)
+
+// unsupportedFormat names the format of a stream that isn't MP3, or returns an
+// empty string. The probe is checked as well, as many servers send a generic
+// content type.
+func unsupportedFormat(contentType string, probe []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if format, ok := UNSUPPORTED_CONTENT_TYPES[strings.ToLower(mediaType)]; ok {
+			return format
+		}
+	}
+
+	for _, m := range UNSUPPORTED_MAGIC {
+		if len(probe) >= m.offset+len(m.magic) && bytes.Equal(probe[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format
+		}
+	}
+
+	// ADTS shares the MPEG sync word, but always has layer 0, which MP3 never uses.
+	if start := id3v2Size(probe); start+ADTS_HEADER_SIZE <= int64(len(probe)) {
+		header := probe[start:]
+		if header[0] == 0xFF && header[1]&0xF6 == 0xF0 {
+			return "aac"
+		}
+	}
+
+	// Mostly error pages of origins that answered with 200 anyway. Only the
+	// probe decides, as some servers label MP3s as text.
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(probe))
+	switch sniffed {
+	case "text/html":
+		return "html"
+	case "text/xml":
+		return "xml"
+	}
+	// Cut off by the probe size, so it's not parsed.
+	if trimmed := bytes.TrimSpace(probe); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return "json"
+	}
+
+	return ""
+}
@@ -4,13 +4,18 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"path"
+	"slices"
 	"strings"
 )
 
 func ValidateHost(urlStr string) (string, error) {
+	cfg := GetConfig()
+
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
-		return "", fmt.Errorf("parse URL: %w", err)
+		// url.Error quotes the whole input, which may contain a password.
+		return "", fmt.Errorf("parse URL: %w", err.(*url.Error).Err)
 	}
 
 	switch parsedURL.Scheme {
@@ -31,6 +36,10 @@ func ValidateHost(urlStr string) (string, error) {
 		return "", fmt.Errorf("empty hostname")
 	}
 
+	if !hostAllowed(strings.ToLower(host), cfg) {
+		return "", fmt.Errorf("host not allowed: %s", host)
+	}
+
 	if cfg.PrivateIPAddressEnabled && cfg.PublicIPAddressEnabled {
 		return host, nil
 	}
@@ -67,3 +76,19 @@ func ValidateHost(urlStr string) (string, error) {
 
 	return ip.String(), nil
 }
+
+// Patterns are globs matched against the hostname, e.g. `*.example.com`.
+// The denylist wins, and an empty allowlist allows every host.
+func hostAllowed(host string, cfg Config) bool {
+	if matchesAnyHost(host, cfg.HostDenylist) {
+		return false
+	}
+	return len(cfg.HostAllowlist) == 0 || matchesAnyHost(host, cfg.HostAllowlist)
+}
+
+func matchesAnyHost(host string, patterns []string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, host)
+		return matched
+	})
+}
@@ -3,13 +3,15 @@ package source
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,12 +23,23 @@ import (
 )
 
 const (
-	OPUS_SAMPLE_RATE       = 48000
-	OPUS_CHANNELS          = 2
+	OPUS_SAMPLE_RATE = 48000
+	OPUS_CHANNELS    = 2
+
+	// Not configurable: the voice transport sends exactly one packet every 20ms
+	// and advances the RTP timestamp by 960 samples per packet, so any other
+	// opus frame duration would play too fast or too slow on Discord's side.
 	OPUS_FRAME_SIZE        = 960
 	OPUS_FRAME_DURATION_MS = OPUS_FRAME_SIZE * 1000 / OPUS_SAMPLE_RATE
 	OPUS_MAX_FRAME_BYTES   = 4000
 
+	// With DTX enabled, libopus marks frames that need not be transmitted by
+	// returning at most this many bytes.
+	OPUS_DTX_FRAME_BYTES = 2
+
+	// 200ms of skipped frames before the track is ended as failed.
+	MAX_ENCODE_FAILURES = 10
+
 	DIAL_TIMEOUT       = 30 * time.Second
 	KEEPALIVE_INTERVAL = 30 * time.Second
 
@@ -36,17 +49,14 @@ const (
 	MPEG2_SAMPLES_PER_FRAME     = 576
 	MPEG2_SAMPLE_RATE_THRESHOLD = 32000
 
-	XING_FLAGS_OFFSET     = 4
-	XING_DATA_OFFSET      = 8
-	XING_FRAME_COUNT_SIZE = 4
-
-	VBRI_FRAME_COUNT_OFFSET = 14
-	VBRI_MIN_SIZE           = 18
-
 	BITS_PER_BYTE = 8
 )
 
+// The rates MPEG 1, 2 and 2.5 audio can have.
+var MP3_SAMPLE_RATES = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+
 var baseTransport = &http.Transport{
+	Proxy:                 proxyFromConfig,
 	ResponseHeaderTimeout: DIAL_TIMEOUT,
 	DialContext: (&net.Dialer{
 		Timeout:   DIAL_TIMEOUT,
@@ -67,12 +77,17 @@ func clientForIP(ip string) *http.Client {
 	}
 
 	transport := baseTransport.Clone()
-	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		_, port, err := net.SplitHostPort(addr)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse address %q: %w", addr, err)
+
+	// Behind a proxy the dial goes to the proxy, which resolves the origin
+	// itself, so pinning it to the validated IP would break the connection.
+	if proxy, _ := ProxyURL(); proxy == nil {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse address %q: %w", addr, err)
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
 		}
-		return dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
 	}
 
 	client := &http.Client{
@@ -95,75 +110,160 @@ type MP3Source struct {
 	decoder   *minimp3.Decoder
 	pcmReader io.Reader
 	encoder   *opus.Encoder
+	// Which pool bucket the encoder goes back to.
+	encoderKey encoderKey
+	// Close hands the encoder back to the pool, encoderMu keeps it from doing
+	// so in the middle of a frame.
+	encoders  *EncoderPool
+	encoderMu sync.Mutex
 
 	pcmBuffer    []byte
 	inputSamples []int16
 	pcmSamples   []int16
+	monoSamples  []int16
 	opusBuffer   []byte
 
 	srcSampleRate int
 	srcChannels   int
 	resampleRatio float64
-	duration      int64
+	// Source samples per channel that make up one opus frame, the fraction
+	// carries over so rates like 11025Hz don't play a little too fast.
+	inputStep  float64
+	inputCarry float64
+	duration   int64
 
 	filterProc *filter.Processor
+	normalizer *filter.Normalizer
+	agc        *filter.AGC
+
+	seeker *mp3Seeker
+
+	// Nil for sources read from memory, replaced on every seek.
+	readahead atomic.Pointer[readahead]
+	bufferMs  int
+	kbps      int
+	counters  *counters
+
+	// Shared with the body readers so bytes from before a seek are kept.
+	bytesRead *atomic.Int64
+	// Played from the source cache, nothing is downloaded.
+	cached bool
+
+	dtx     bool
+	bitrate int
+
+	// Consecutive frames that failed to encode, only touched by the frame provider.
+	encodeFailures int
+	// Whether a full frame was ever read, an origin that ends before that
+	// sent nothing playable.
+	started bool
+
+	// Applied by the frame provider before its next frame, so changing filters
+	// never waits for a frame to be encoded.
+	pendingFilters atomic.Pointer[filterChange]
+
+	// Like filters, volume changes are applied by the frame provider, which
+	// owns the ramp.
+	pendingVolume atomic.Pointer[volumeChange]
+	volume        *filter.VolumeRamp
 
 	position atomic.Int64
-	closed   atomic.Bool
-	mutex    sync.Mutex
+	// Source samples times 1000 not yet counted in position, see advancePosition.
+	positionRemainder int64
+	closed            atomic.Bool
+	// Only guards the decoder and body, which seeks replace and Close frees.
+	// Everything after decoding is only touched by the frame provider.
+	mutex sync.Mutex
+	// Set by a seek, so the first frame from the new position is encoded
+	// without the encoder state of the audio before it. Guarded by mutex.
+	seeked bool
 }
 
-func NewMP3Source(ctx context.Context, urlStr, ip string, startTimeMs int64, filters *filter.Filters) (*MP3Source, error) {
+type filterChange struct {
+	filters *filter.Filters
+}
+
+type volumeChange struct {
+	volume int
+	rampMs int
+}
+
+func NewMP3Source(ctx context.Context, urlStr, ip string, opts Options) (*MP3Source, error) {
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, fmt.Errorf("parse URL: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", parsedURL.String(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
-
-	req.Header.Set("User-Agent", cfg.UserAgent)
-
-	resp, err := clientForIP(ip).Do(req)
+	org, err := openOrigin(ctx, parsedURL.String(), ip)
 	if err != nil {
-		return nil, fmt.Errorf("fetch audio: %w", err)
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
-	}
+	bufferMs := opts.bufferMs()
+	ra := newReadahead(org.body, bufferBytes(max(bufferMs, MIN_READAHEAD_MS), MAX_MP3_KBPS), org.counters)
+	body := io.ReadCloser(ra)
 
 	rawProbe := make([]byte, PROBE_SIZE)
-	rn, err := io.ReadFull(resp.Body, rawProbe)
+	rn, err := io.ReadFull(body, rawProbe)
+	if err == io.EOF {
+		body.Close()
+		return nil, ErrEmptyStream
+	}
 	if err != nil && err != io.ErrUnexpectedEOF {
-		resp.Body.Close()
+		body.Close()
 		return nil, fmt.Errorf("read initial data: %w", err)
 	}
 
 	rawProbe = rawProbe[:rn]
-	xingFrames := parseXingFrames(rawProbe)
+	if format := unsupportedFormat(org.contentType, rawProbe); format != "" {
+		body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	vbr := parseVBRHeader(rawProbe)
 
 	reader := &prefixedReadCloser{
-		Reader: io.MultiReader(bytes.NewReader(rawProbe), resp.Body),
-		closer: resp.Body,
+		Reader: io.MultiReader(bytes.NewReader(rawProbe), body),
+		closer: body,
 	}
 
-	source, err := NewMP3SourceFromReader(reader, urlStr, startTimeMs, filters)
+	source, err := NewMP3SourceFromReader(reader, urlStr, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	if xingFrames > 0 && source.srcSampleRate > 0 {
-		samplesPerFrame := int64(MPEG1_SAMPLES_PER_FRAME)
-		if source.srcSampleRate < MPEG2_SAMPLE_RATE_THRESHOLD {
-			samplesPerFrame = MPEG2_SAMPLES_PER_FRAME
+	source.bufferMs = bufferMs
+	source.counters = org.counters
+	source.cached = org.cached
+	source.readahead.Store(ra)
+	ra.setLimit(source.bufferLimit())
+
+	audioStart := id3v2Size(rawProbe)
+	audioBytes := org.contentLength - audioStart
+
+	if vbr.frames > 0 && source.srcSampleRate > 0 {
+		source.duration = vbr.frames * source.samplesPerFrame() * 1000 / int64(source.srcSampleRate)
+	} else if audioBytes > 0 && source.decoder.Kbps > 0 {
+		source.duration = audioBytes * BITS_PER_BYTE / int64(source.decoder.Kbps)
+	}
+
+	if vbr.bytes > 0 {
+		audioBytes = vbr.bytes
+	}
+
+	if org.openAt != nil && source.duration > 0 && audioBytes > 0 {
+		source.seeker = &mp3Seeker{
+			open:       org.openAt,
+			audioStart: audioStart,
+			audioBytes: audioBytes,
+			toc:        vbr.toc,
+		}
+	}
+
+	if opts.StartTimeMs > 0 && source.CanSeek() {
+		if err := source.SeekTo(opts.StartTimeMs); err != nil {
+			source.Close()
+			return nil, fmt.Errorf("seek to start time: %w", err)
 		}
-		source.duration = xingFrames * samplesPerFrame * 1000 / int64(source.srcSampleRate)
-	} else if resp.ContentLength > 0 && source.decoder.Kbps > 0 {
-		source.duration = resp.ContentLength * BITS_PER_BYTE / int64(source.decoder.Kbps)
 	}
 
 	return source, nil
@@ -178,39 +278,21 @@ func (r *prefixedReadCloser) Close() error {
 	return r.closer.Close()
 }
 
-var (
-	tagXing = []byte("Xing")
-	tagInfo = []byte("Info")
-	tagVBRI = []byte("VBRI")
-)
-
-func parseXingFrames(data []byte) int64 {
-	for _, tag := range [2][]byte{tagXing, tagInfo} {
-		idx := bytes.Index(data, tag)
-		if idx < 0 || idx+XING_DATA_OFFSET > len(data) {
-			continue
-		}
-
-		flags := binary.BigEndian.Uint32(data[idx+XING_FLAGS_OFFSET:])
-		offset := idx + XING_DATA_OFFSET
-
-		if flags&1 != 0 {
-			if offset+XING_FRAME_COUNT_SIZE > len(data) {
-				continue
-			}
-
-			return int64(binary.BigEndian.Uint32(data[offset:]))
-		}
-	}
-
-	if idx := bytes.Index(data, tagVBRI); idx >= 0 && idx+VBRI_MIN_SIZE <= len(data) {
-		return int64(binary.BigEndian.Uint32(data[idx+VBRI_FRAME_COUNT_OFFSET:]))
-	}
+type countingReadCloser struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
 
-	return 0
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
 }
 
-func NewMP3SourceFromReader(reader io.ReadCloser, url string, startTimeMs int64, filters *filter.Filters) (*MP3Source, error) {
+func NewMP3SourceFromReader(reader io.ReadCloser, url string, opts Options) (*MP3Source, error) {
+	bytesRead := &atomic.Int64{}
+	reader = &countingReadCloser{ReadCloser: reader, n: bytesRead}
+
 	decoder, err := minimp3.NewDecoder(reader)
 	if err != nil {
 		reader.Close()
@@ -227,46 +309,74 @@ func NewMP3SourceFromReader(reader io.ReadCloser, url string, startTimeMs int64,
 	if n == 0 {
 		decoder.Close()
 		reader.Close()
-		return nil, fmt.Errorf("empty mp3 stream")
+		return nil, ErrEmptyStream
 	}
 
+	// Taken from the first decoded frame, everything after is read with this
+	// layout: mono is upmixed before resampling, which steps over stereo pairs.
 	srcSampleRate := decoder.SampleRate
 	srcChannels := decoder.Channels
-	if srcChannels < 1 || srcChannels > 2 {
+	if srcChannels < 1 || srcChannels > OPUS_CHANNELS {
 		decoder.Close()
 		reader.Close()
 		return nil, fmt.Errorf("unsupported channel count: %d", srcChannels)
 	}
-
-	baseResampleRatio := float64(OPUS_SAMPLE_RATE) / float64(srcSampleRate)
-
-	var filterProc *filter.Processor
-	effectiveResampleRatio := baseResampleRatio
-	if filters != nil && !filters.IsEmpty() {
-		filterProc = filter.NewProcessor(filters, float64(OPUS_SAMPLE_RATE))
-		effectiveResampleRatio = baseResampleRatio / (filterProc.PitchRatio() * filterProc.TimescaleRatio())
+	if srcSampleRate <= 0 {
+		decoder.Close()
+		reader.Close()
+		return nil, fmt.Errorf("unsupported sample rate: %d", srcSampleRate)
+	}
+	if !slices.Contains(MP3_SAMPLE_RATES, srcSampleRate) {
+		slog.Warn("mp3 stream has an unusual sample rate, it is resampled but may sound off",
+			slog.String("url", RedactURL(url)),
+			slog.Int("sample_rate", srcSampleRate),
+		)
 	}
 
-	inputSamplesPerChannel := int(float64(OPUS_FRAME_SIZE) / effectiveResampleRatio)
+	cfg := GetConfig()
 
-	const maxInputSamples = OPUS_FRAME_SIZE * 4
+	encodeChannels := OPUS_CHANNELS
+	if cfg.OpusMono {
+		encodeChannels = 1
+	}
 
-	if inputSamplesPerChannel < 1 {
-		inputSamplesPerChannel = 1
+	encoderKey := encoderKey{channels: encodeChannels, application: opts.application()}
+	encoder, err := opts.Encoders.get(encoderKey)
+	if err != nil {
+		decoder.Close()
+		reader.Close()
+		return nil, fmt.Errorf("create opus encoder: %w", err)
 	}
-	if inputSamplesPerChannel > maxInputSamples {
-		inputSamplesPerChannel = maxInputSamples
+
+	if err := encoder.SetComplexity(cfg.OpusComplexity); err != nil {
+		decoder.Close()
+		reader.Close()
+		return nil, fmt.Errorf("set opus complexity: %w", err)
 	}
 
-	inputFrameBytes := inputSamplesPerChannel * srcChannels * 2
-	sampleAlign := srcChannels * 2
-	inputFrameBytes = ((inputFrameBytes + sampleAlign - 1) / sampleAlign) * sampleAlign
+	// Set either way, a pooled encoder still has the last track's settings.
+	if err := encoder.SetDTX(cfg.OpusDTX); err != nil {
+		decoder.Close()
+		reader.Close()
+		return nil, fmt.Errorf("set opus dtx: %w", err)
+	}
 
-	encoder, err := opus.NewEncoder(OPUS_SAMPLE_RATE, OPUS_CHANNELS, opus.AppAudio)
+	if bitrate := opts.bitrate(); bitrate > 0 {
+		err = encoder.SetBitrate(bitrate)
+	} else {
+		err = encoder.SetBitrateToAuto()
+	}
 	if err != nil {
 		decoder.Close()
 		reader.Close()
-		return nil, fmt.Errorf("create opus encoder: %w", err)
+		return nil, fmt.Errorf("set opus bitrate: %w", err)
+	}
+	// Reports what libopus chose when no bitrate is set.
+	bitrate, err := encoder.Bitrate()
+	if err != nil {
+		decoder.Close()
+		reader.Close()
+		return nil, fmt.Errorf("get opus bitrate: %w", err)
 	}
 
 	pcmReader := io.MultiReader(bytes.NewReader(probe[:n]), decoder)
@@ -277,70 +387,235 @@ func NewMP3SourceFromReader(reader io.ReadCloser, url string, startTimeMs int64,
 		decoder:       decoder,
 		pcmReader:     pcmReader,
 		encoder:       encoder,
-		pcmBuffer:     make([]byte, inputFrameBytes),
-		inputSamples:  make([]int16, inputSamplesPerChannel*OPUS_CHANNELS),
+		encoderKey:    encoderKey,
+		encoders:      opts.Encoders,
 		pcmSamples:    make([]int16, OPUS_FRAME_SIZE*OPUS_CHANNELS),
 		opusBuffer:    make([]byte, OPUS_MAX_FRAME_BYTES),
 		srcSampleRate: srcSampleRate,
 		srcChannels:   srcChannels,
-		resampleRatio: effectiveResampleRatio,
-		filterProc:    filterProc,
+		kbps:          decoder.Kbps,
+		bytesRead:     bytesRead,
+		counters:      &counters{},
+		dtx:           cfg.OpusDTX,
+		bitrate:       bitrate,
+	}
+
+	if opts.normalize() {
+		source.normalizer = filter.NewNormalizer(float64(OPUS_SAMPLE_RATE))
+	}
+
+	if cfg.AGCEnabled {
+		source.agc = filter.NewAGC(float64(OPUS_SAMPLE_RATE), cfg.AGCTargetDB, cfg.AGCAttackMs, cfg.AGCReleaseMs)
 	}
 
-	source.position.Store(startTimeMs)
+	// Filters work on interleaved stereo, so mono is only produced right before encoding.
+	if encodeChannels == 1 {
+		source.monoSamples = make([]int16, OPUS_FRAME_SIZE)
+	}
+
+	source.applyFilters(opts.Filters)
+	source.volume = filter.NewVolumeRamp(float64(OPUS_SAMPLE_RATE), opts.volume())
 
 	return source, nil
 }
 
-func (s *MP3Source) ProvideOpusFrame() ([]byte, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// Pitch and speed change how many source samples make up one opus frame,
+// so the input buffers are resized together with the filter chain.
+func (s *MP3Source) applyFilters(filters *filter.Filters) {
+	baseResampleRatio := float64(OPUS_SAMPLE_RATE) / float64(s.srcSampleRate)
 
-	if s.closed.Load() || len(s.pcmBuffer) == 0 {
-		return nil, io.EOF
+	var filterProc *filter.Processor
+	effectiveResampleRatio := baseResampleRatio
+	speed := 1.0
+	if !filters.IsEmpty() {
+		filterProc = filter.NewProcessor(filters, float64(OPUS_SAMPLE_RATE), OPUS_FRAME_SIZE)
+		speed = filterProc.PitchRatio() * filterProc.TimescaleRatio()
+		effectiveResampleRatio = baseResampleRatio / speed
 	}
 
-	_, err := io.ReadFull(s.pcmReader, s.pcmBuffer)
-	if err != nil {
+	const maxInputSamples = OPUS_FRAME_SIZE * 4
+
+	// Multiplied out rather than divided by the ratio, which would turn 441 into 440.999.
+	inputStep := float64(OPUS_FRAME_SIZE*s.srcSampleRate) / OPUS_SAMPLE_RATE * speed
+	inputStep = min(max(inputStep, 1), maxInputSamples)
+	inputSamplesPerChannel := int(math.Ceil(inputStep))
+
+	// pcmBuffer holds whole frames of the source layout, inputSamples is always stereo.
+	s.pcmBuffer = make([]byte, inputSamplesPerChannel*s.srcChannels*2)
+	s.inputSamples = make([]int16, inputSamplesPerChannel*OPUS_CHANNELS)
+	s.inputStep = inputStep
+	s.inputCarry = 0
+	s.resampleRatio = effectiveResampleRatio
+	s.filterProc = filterProc
+}
+
+func (s *MP3Source) SetFilters(filters *filter.Filters) {
+	s.pendingFilters.Store(&filterChange{filters: filters})
+}
+
+func (s *MP3Source) SetVolume(volume, rampMs int) {
+	s.pendingVolume.Store(&volumeChange{volume: volume, rampMs: rampMs})
+}
+
+func (s *MP3Source) ProvideOpusFrame() ([]byte, error) {
+	if change := s.pendingFilters.Swap(nil); change != nil {
+		s.applyFilters(change.filters)
+	}
+	if change := s.pendingVolume.Swap(nil); change != nil {
+		s.volume.Set(change.volume, change.rampMs)
+	}
+
+	inputLen := s.nextInputLen()
+	pcm := s.pcmBuffer[:inputLen*s.srcChannels*2]
+	input := s.inputSamples[:inputLen*OPUS_CHANNELS]
+
+	if err := s.readPCM(pcm); err != nil {
 		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			if !s.started {
+				return nil, ErrEmptyStream
+			}
 			return nil, io.EOF
 		}
+		if !s.closed.Load() {
+			s.counters.decodeErrors.Add(1)
+		}
 		return nil, fmt.Errorf("read pcm: %w", err)
 	}
+	s.started = true
 
-	numSamplesPerChannel := len(s.pcmBuffer) / (s.srcChannels * 2)
-	rawSamples := unsafe.Slice((*int16)(unsafe.Pointer(&s.pcmBuffer[0])), len(s.pcmBuffer)/2)
+	rawSamples := unsafe.Slice((*int16)(unsafe.Pointer(&pcm[0])), len(pcm)/2)
 
 	if s.srcChannels == 1 {
-		for i := range numSamplesPerChannel {
-			sample := rawSamples[i]
-			s.inputSamples[i*2] = sample
-			s.inputSamples[i*2+1] = sample
-		}
+		upmixMono(rawSamples, input)
 	} else {
-		copy(s.inputSamples, rawSamples)
+		copy(input, rawSamples)
 	}
 
 	if s.resampleRatio != 1.0 {
-		s.resampleLinear(s.inputSamples, s.pcmSamples)
+		s.resampleLinear(input, s.pcmSamples)
 	} else {
-		copy(s.pcmSamples, s.inputSamples)
+		copy(s.pcmSamples, input)
 	}
 
 	if s.filterProc != nil {
 		s.filterProc.Process(s.pcmSamples)
 	}
 
-	numBytes, err := s.encoder.Encode(s.pcmSamples, s.opusBuffer)
+	if s.normalizer != nil {
+		s.normalizer.Process(s.pcmSamples)
+	}
+
+	if s.agc != nil {
+		s.agc.Process(s.pcmSamples)
+	}
+
+	s.volume.Process(s.pcmSamples, OPUS_CHANNELS)
+
+	samples := s.pcmSamples
+	if s.monoSamples != nil {
+		downmixStereo(s.pcmSamples, s.monoSamples)
+		samples = s.monoSamples
+	}
+
+	numBytes, err := s.encode(samples)
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
 	if err != nil {
-		return nil, fmt.Errorf("encode opus: %w", err)
+		return s.skipFrame(err)
 	}
+	s.encodeFailures = 0
 
-	s.position.Add(OPUS_FRAME_DURATION_MS)
+	// A nil frame makes the voice connection send its silence frames and stop
+	// transmitting until audio resumes, which Discord handles like a pause.
+	if s.dtx && numBytes <= OPUS_DTX_FRAME_BYTES {
+		return nil, nil
+	}
 
 	return s.opusBuffer[:numBytes], nil
 }
 
+func (s *MP3Source) encode(samples []int16) (int, error) {
+	s.encoderMu.Lock()
+	defer s.encoderMu.Unlock()
+
+	// Closed while the frame was decoded.
+	if s.encoder == nil {
+		return 0, io.EOF
+	}
+	return s.encoder.Encode(samples, s.opusBuffer)
+}
+
+// nextInputLen is how many source samples per channel the next opus frame is
+// made from.
+func (s *MP3Source) nextInputLen() int {
+	step := s.inputStep + s.inputCarry
+	n := int(step)
+	s.inputCarry = step - float64(n)
+	return n
+}
+
+// readPCM decodes the next frame's input from the readahead buffer. The
+// position moves along under the mutex, so a seek can't land between
+// decoding a frame and counting it.
+func (s *MP3Source) readPCM(pcm []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed.Load() || len(pcm) == 0 {
+		return io.EOF
+	}
+
+	if _, err := io.ReadFull(s.pcmReader, pcm); err != nil {
+		return err
+	}
+	if s.seeked {
+		s.seeked = false
+		// Keeps bitrate, complexity and DTX, only the predictions from the
+		// audio before the seek are dropped.
+		if err := s.encoder.Reset(); err != nil {
+			return fmt.Errorf("reset opus encoder: %w", err)
+		}
+	}
+	s.advancePosition(len(pcm) / (s.srcChannels * 2))
+	return nil
+}
+
+// advancePosition counts the source samples that were read rather than opus
+// frames sent, which differ with speed filters and with rates that aren't a
+// whole number of samples per frame. The remainder below a millisecond is
+// carried, so the position doesn't drift over hours. Called with the mutex held.
+func (s *MP3Source) advancePosition(samples int) {
+	s.positionRemainder += int64(samples) * 1000
+	ms := s.positionRemainder / int64(s.srcSampleRate)
+	s.positionRemainder -= ms * int64(s.srcSampleRate)
+	s.position.Add(ms)
+}
+
+// A single bad frame shouldn't end a long stream, so it is replaced with
+// silence and the track only fails once encoding keeps failing.
+func (s *MP3Source) skipFrame(err error) ([]byte, error) {
+	s.counters.decodeErrors.Add(1)
+	s.encodeFailures++
+	if s.encodeFailures >= MAX_ENCODE_FAILURES {
+		return nil, fmt.Errorf("encode opus: %d frames in a row failed: %w", s.encodeFailures, err)
+	}
+	return nil, nil
+}
+
+func upmixMono(mono, stereo []int16) {
+	for i, sample := range mono {
+		stereo[i*2] = sample
+		stereo[i*2+1] = sample
+	}
+}
+
+func downmixStereo(stereo, mono []int16) {
+	for i := range mono {
+		mono[i] = int16((int32(stereo[i*2]) + int32(stereo[i*2+1])) / 2)
+	}
+}
+
 func (s *MP3Source) resampleLinear(input, output []int16) {
 	inputLen := len(input) / OPUS_CHANNELS
 	outputLen := len(output) / OPUS_CHANNELS
@@ -391,12 +666,16 @@ func (s *MP3Source) Close() {
 	s.body.Close()
 
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	s.decoder.Close()
 	s.decoder = nil
 	s.pcmReader = nil
 	s.body = nil
+	s.mutex.Unlock()
+
+	s.encoderMu.Lock()
+	s.encoders.put(s.encoderKey, s.encoder)
+	s.encoder = nil
+	s.encoderMu.Unlock()
 }
 
 func (s *MP3Source) Position() int64 {
@@ -404,7 +683,108 @@ func (s *MP3Source) Position() int64 {
 }
 
 func (s *MP3Source) SeekTo(positionMs int64) error {
-	return errors.New("seek not supported for HTTP streams")
+	if !s.CanSeek() {
+		return errors.New("seek not supported for HTTP streams")
+	}
+
+	positionMs = s.frameStart(max(0, min(positionMs, s.duration)))
+
+	rawBody, err := s.seeker.open(context.Background(), s.seeker.offset(positionMs, s.duration))
+	if err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+	ra := newReadahead(rawBody, s.bufferLimit(), s.counters)
+	body := &countingReadCloser{ReadCloser: ra, n: s.bytesRead}
+
+	decoder, err := minimp3.NewDecoder(body)
+	if err != nil {
+		body.Close()
+		return fmt.Errorf("seek: create mp3 decoder: %w", err)
+	}
+
+	s.mutex.Lock()
+	if s.closed.Load() {
+		s.mutex.Unlock()
+		decoder.Close()
+		body.Close()
+		return errors.New("source closed")
+	}
+
+	oldBody, oldDecoder := s.body, s.decoder
+	s.body = body
+	s.decoder = decoder
+	s.pcmReader = decoder
+	s.position.Store(positionMs)
+	s.positionRemainder = 0
+	s.seeked = true
+	s.readahead.Store(ra)
+	s.mutex.Unlock()
+
+	oldBody.Close()
+	oldDecoder.Close()
+
+	return nil
+}
+
+func (s *MP3Source) samplesPerFrame() int64 {
+	if s.srcSampleRate < MPEG2_SAMPLE_RATE_THRESHOLD {
+		return MPEG2_SAMPLES_PER_FRAME
+	}
+	return MPEG1_SAMPLES_PER_FRAME
+}
+
+// frameStart rounds down to the start of the frame positionMs falls into, as
+// decoding can only begin at a frame. Reporting that instead of the requested
+// position keeps the position from running ahead of the audio.
+func (s *MP3Source) frameStart(positionMs int64) int64 {
+	if s.srcSampleRate <= 0 {
+		return positionMs
+	}
+	rate := int64(s.srcSampleRate)
+	frame := positionMs * rate / (1000 * s.samplesPerFrame())
+	return frame * s.samplesPerFrame() * 1000 / rate
+}
+
+func (s *MP3Source) BytesRead() int64 {
+	if s.cached {
+		return 0
+	}
+	return s.bytesRead.Load()
+}
+
+func (s *MP3Source) bufferLimit() int {
+	kbps := s.kbps
+	if kbps <= 0 {
+		kbps = MAX_MP3_KBPS
+	}
+	return bufferBytes(max(s.bufferMs, MIN_READAHEAD_MS), kbps)
+}
+
+// BufferState reports the readahead of players without a buffer target too,
+// with the MIN_READAHEAD_MS it fills up to.
+func (s *MP3Source) BufferState() BufferState {
+	ra := s.readahead.Load()
+	if ra == nil || s.kbps <= 0 {
+		return BufferState{}
+	}
+
+	buffered, limit, full := ra.state()
+	return BufferState{
+		BufferedMs:    buffered * BITS_PER_BYTE / s.kbps,
+		TargetMs:      max(s.bufferMs, MIN_READAHEAD_MS),
+		BufferedBytes: buffered,
+		LimitBytes:    limit,
+		Full:          full,
+		Underruns:     s.counters.underruns.Load(),
+	}
+}
+
+func (s *MP3Source) Stats() Stats {
+	return s.counters.stats()
+}
+
+func (s *MP3Source) Bitrate() int {
+	return s.bitrate
 }
 
 func (s *MP3Source) Duration() int64 {
@@ -412,7 +792,7 @@ func (s *MP3Source) Duration() int64 {
 }
 
 func (s *MP3Source) CanSeek() bool {
-	return false
+	return s.seeker != nil
 }
 
 func (s *MP3Source) URL() string {
@@ -0,0 +1,273 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hraban/opus"
+	"github.com/shi-gg/linkdave/server/audio/filter"
+)
+
+// newTestMP3Source skips decoding, pcm is what the decoder would have produced.
+func newTestMP3Source(t testing.TB, pcm io.Reader, sampleRate, channels int) *MP3Source {
+	t.Helper()
+
+	encoder, err := opus.NewEncoder(OPUS_SAMPLE_RATE, OPUS_CHANNELS, opus.AppAudio)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &MP3Source{
+		pcmReader:     pcm,
+		encoder:       encoder,
+		pcmSamples:    make([]int16, OPUS_FRAME_SIZE*OPUS_CHANNELS),
+		opusBuffer:    make([]byte, OPUS_MAX_FRAME_BYTES),
+		srcSampleRate: sampleRate,
+		srcChannels:   channels,
+		counters:      &counters{},
+	}
+	s.applyFilters(nil)
+	s.volume = filter.NewVolumeRamp(float64(OPUS_SAMPLE_RATE), filter.DEFAULT_VOLUME)
+	return s
+}
+
+func TestSkipFrameFailsOnceEncodingKeepsFailing(t *testing.T) {
+	encodeErr := errors.New("bad frame")
+	s := &MP3Source{counters: &counters{}}
+
+	for i := 1; i < MAX_ENCODE_FAILURES; i++ {
+		frame, err := s.skipFrame(encodeErr)
+		if frame != nil || err != nil {
+			t.Fatalf("failure %d = (%v, %v), want a silent frame", i, frame, err)
+		}
+	}
+
+	if _, err := s.skipFrame(encodeErr); !errors.Is(err, encodeErr) {
+		t.Fatalf("failure %d = %v, want the encode error", MAX_ENCODE_FAILURES, err)
+	}
+}
+
+func TestEmptyOriginIsEmptyStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := NewMP3Source(context.Background(), srv.URL, "127.0.0.1", Options{})
+	if !errors.Is(err, ErrEmptyStream) {
+		t.Fatalf("err = %v, want ErrEmptyStream", err)
+	}
+}
+
+func TestStreamWithoutFramesIsEmptyStream(t *testing.T) {
+	s := newTestMP3Source(t, bytes.NewReader(nil), OPUS_SAMPLE_RATE, 2)
+
+	if _, err := s.ProvideOpusFrame(); !errors.Is(err, ErrEmptyStream) {
+		t.Fatalf("err = %v, want ErrEmptyStream", err)
+	}
+}
+
+func TestStreamEndingAfterFramesIsEOF(t *testing.T) {
+	s := newTestMP3Source(t, bytes.NewReader(make([]byte, OPUS_FRAME_SIZE*2*2)), OPUS_SAMPLE_RATE, 2)
+
+	if _, err := s.ProvideOpusFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ProvideOpusFrame(); err != io.EOF {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestLowSampleRateMonoKeepsSpeed(t *testing.T) {
+	const frames = 100
+	// 11025Hz needs 220.5 samples per 20ms frame.
+	pcm := make([]byte, frames*OPUS_FRAME_DURATION_MS*11025/1000*2)
+	s := newTestMP3Source(t, bytes.NewReader(pcm), 11025, 1)
+
+	for i := range frames {
+		if _, err := s.ProvideOpusFrame(); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+	}
+
+	if got := s.Position(); got != frames*OPUS_FRAME_DURATION_MS {
+		t.Fatalf("position = %d, want %d", got, frames*OPUS_FRAME_DURATION_MS)
+	}
+	if _, err := s.ProvideOpusFrame(); err != io.EOF {
+		t.Fatalf("err after all input = %v, want io.EOF", err)
+	}
+}
+
+func TestUpmixMono(t *testing.T) {
+	stereo := make([]int16, 6)
+	upmixMono([]int16{1, -2, 3}, stereo)
+
+	want := []int16{1, 1, -2, -2, 3, 3}
+	if !slices.Equal(stereo, want) {
+		t.Fatalf("stereo = %v, want %v", stereo, want)
+	}
+}
+
+func TestBufferStateWithoutTarget(t *testing.T) {
+	s := newTestMP3Source(t, silence{}, 44100, 2)
+	s.kbps = 128
+	// 250ms at 128kbps, the whole stream.
+	ra := newReadahead(io.NopCloser(bytes.NewReader(make([]byte, 4000))), s.bufferLimit(), s.counters)
+	defer ra.Close()
+	s.readahead.Store(ra)
+
+	for _, _, full := ra.state(); !full; _, _, full = ra.state() {
+		time.Sleep(time.Millisecond)
+	}
+
+	state := s.BufferState()
+	if state.TargetMs != MIN_READAHEAD_MS || state.BufferedMs != 250 {
+		t.Fatalf("buffer = %dms of %dms, want 250ms of %dms", state.BufferedMs, state.TargetMs, MIN_READAHEAD_MS)
+	}
+	if state.BufferedBytes != 4000 || state.LimitBytes != s.bufferLimit() {
+		t.Fatalf("buffer = %d of %d bytes, want 4000 of %d", state.BufferedBytes, state.LimitBytes, s.bufferLimit())
+	}
+}
+
+// silence never runs out, like a long stream.
+type silence struct{}
+
+func (silence) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestPositionDoesNotDriftOverHours(t *testing.T) {
+	hours := 3
+	if testing.Short() {
+		hours = 1
+	}
+	frames := hours * 3600 * 1000 / OPUS_FRAME_DURATION_MS
+
+	for _, rate := range []int{11025, 22050, 44100} {
+		t.Run(strconv.Itoa(rate), func(t *testing.T) {
+			s := newTestMP3Source(t, silence{}, rate, 2)
+
+			for i := range frames {
+				if _, err := s.ProvideOpusFrame(); err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+			}
+
+			want := int64(frames * OPUS_FRAME_DURATION_MS)
+			if got := s.Position(); got < want-1 || got > want+1 {
+				t.Fatalf("position after %dh = %d, want %d±1", hours, got, want)
+			}
+		})
+	}
+}
+
+// Filters and volume are handed over through atomic pointers, run with -race.
+func TestChangesWhilePlaying(t *testing.T) {
+	s := newTestMP3Source(t, silence{}, 44100, 2)
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for i := range 200 {
+			s.SetFilters(&filter.Filters{Speed: 1 + float64(i%3)/10})
+			s.SetVolume(i%150, 50)
+		}
+	})
+
+	for range 200 {
+		if _, err := s.ProvideOpusFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func BenchmarkProvideOpusFrame(b *testing.B) {
+	s := newTestMP3Source(b, silence{}, 44100, 2)
+	s.SetFilters(&filter.Filters{Enabled: []filter.Type{filter.Nightcore}})
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := s.ProvideOpusFrame(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// slowOrigin hands out PCM like a distant origin, every read waits for delay.
+type slowOrigin struct {
+	delay time.Duration
+}
+
+func (o slowOrigin) Read(p []byte) (int, error) {
+	time.Sleep(o.delay)
+	clear(p)
+	return len(p), nil
+}
+
+func (slowOrigin) Close() error {
+	return nil
+}
+
+// Paced like voice send loops, with the latency being how long each
+// ProvideOpusFrame held up its loop. Reading the origin inline is the
+// baseline the readahead is measured against.
+func BenchmarkProvideOpusFrameSlowOrigin(b *testing.B) {
+	const sources = 500
+	origin := slowOrigin{delay: 5 * time.Millisecond}
+
+	for _, bench := range []struct {
+		name      string
+		readahead bool
+	}{
+		{"readahead", true},
+		{"direct", false},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			frames := max(b.N/sources, 1)
+			players := make([]*MP3Source, sources)
+			for i := range players {
+				var pcm io.Reader = origin
+				if bench.readahead {
+					ra := newReadahead(origin, bufferBytes(MIN_READAHEAD_MS, MAX_MP3_KBPS), &counters{})
+					defer ra.Close()
+					pcm = ra
+				}
+				players[i] = newTestMP3Source(b, pcm, 44100, 2)
+			}
+
+			latencies := make([]time.Duration, sources*frames)
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for i, s := range players {
+				wg.Go(func() {
+					ticker := time.NewTicker(OPUS_FRAME_DURATION_MS * time.Millisecond)
+					defer ticker.Stop()
+
+					for f := range frames {
+						<-ticker.C
+						start := time.Now()
+						if _, err := s.ProvideOpusFrame(); err != nil {
+							b.Error(err)
+							return
+						}
+						latencies[i*frames+f] = time.Since(start)
+					}
+				})
+			}
+			wg.Wait()
+			b.StopTimer()
+
+			slices.Sort(latencies)
+			b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds()), "p50-µs/frame")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs/frame")
+		})
+	}
+}
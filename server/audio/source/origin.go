@@ -0,0 +1,128 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// origin is where a source's bytes come from, the network or the source cache.
+type origin struct {
+	body io.ReadCloser
+	// -1 when unknown, as for compressed responses.
+	contentLength int64
+	contentType   string
+	// Nil when reading can't start at an arbitrary byte offset.
+	openAt func(ctx context.Context, offset int64) (io.ReadCloser, error)
+	cached bool
+	// Also counts the requests made by openAt.
+	counters *counters
+}
+
+func openOrigin(ctx context.Context, url, ip string) (*origin, error) {
+	counters := &counters{}
+	org, err := fetchOrigin(ctx, url, ip, counters)
+	if err != nil {
+		return nil, err
+	}
+	org.counters = counters
+	return org, nil
+}
+
+func fetchOrigin(ctx context.Context, url, ip string, counters *counters) (*origin, error) {
+	cache := sourceCache.Load()
+
+	var entry *cacheEntry
+	if cache != nil {
+		entry = cache.lookup(url)
+		if entry != nil && entry.fresh() {
+			if org, err := cache.open(entry); err == nil {
+				return org, nil
+			}
+			entry = nil
+		}
+	}
+
+	client := clientForIP(ip)
+	resp, err := doWithRetry(ctx, client, &counters.reconnects, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", GetConfig().UserAgent)
+		if entry != nil {
+			entry.setValidators(req.Header)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		resp.Body.Close()
+		if org, err := cache.revalidated(entry, resp.Header); err == nil {
+			return org, nil
+		}
+		// The entry is gone now, so this is an unconditional request.
+		return fetchOrigin(ctx, url, ip, counters)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	body, compressed, err := decompressBody(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	org := &origin{
+		body:          body,
+		contentLength: resp.ContentLength,
+		contentType:   resp.Header.Get("Content-Type"),
+	}
+
+	// Byte ranges address the compressed representation, which the decoder can't start from.
+	if compressed {
+		org.contentLength = -1
+		return org, nil
+	}
+
+	if resp.Header.Get("Accept-Ranges") == "bytes" {
+		org.openAt = func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+			return openRange(ctx, client, &counters.reconnects, url, offset)
+		}
+		if cache != nil {
+			org.body = cache.store(url, resp, body)
+		}
+	}
+
+	return org, nil
+}
+
+func openRange(ctx context.Context, client *http.Client, reconnects *atomic.Int64, url string, offset int64) (io.ReadCloser, error) {
+	resp, err := doWithRetry(ctx, client, reconnects, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", GetConfig().UserAgent)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status for range request: %d", resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
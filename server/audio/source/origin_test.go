@@ -0,0 +1,63 @@
+package source
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestRevalidatedEntryWithMissingDataIsFetchedAgain(t *testing.T) {
+	const body = "audio bytes"
+	var unconditional int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		unconditional++
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	if err := EnableCache(t.TempDir(), 0); err != nil {
+		t.Fatal(err)
+	}
+	cache := sourceCache.Load()
+	defer sourceCache.Store(nil)
+
+	read := func() string {
+		t.Helper()
+		org, err := fetchOrigin(context.Background(), srv.URL, "127.0.0.1", &counters{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer org.body.Close()
+		data, err := io.ReadAll(org.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := read(); got != body {
+		t.Fatalf("first read = %q", got)
+	}
+	if err := os.Remove(cache.path(cacheKey(srv.URL), CACHE_DATA_EXT)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := read(); got != body {
+		t.Fatalf("read after losing the cached file = %q", got)
+	}
+	if unconditional != 2 {
+		t.Fatalf("unconditional requests = %d, want 2", unconditional)
+	}
+}
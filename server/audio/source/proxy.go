@@ -0,0 +1,33 @@
+package source
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ProxyURL returns the egress proxy for source fetches, or nil when sources
+// are fetched directly.
+func ProxyURL() (*url.URL, error) {
+	raw := GetConfig().ProxyURL
+	if raw == "" {
+		return nil, nil
+	}
+
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		// url.Error quotes the whole input, which may contain a password.
+		return nil, fmt.Errorf("parse proxy url: %w", err.(*url.Error).Err)
+	}
+
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return proxy, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", proxy.Scheme)
+	}
+}
+
+func proxyFromConfig(*http.Request) (*url.URL, error) {
+	return ProxyURL()
+}
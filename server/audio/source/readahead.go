@@ -0,0 +1,132 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// Upper bound for the per player buffer target, deeper buffers mostly add memory.
+	MAX_BUFFER_MS = 10_000
+
+	// Used to size the buffer before the decoder knows the real bitrate,
+	// the highest mp3 bitrate so the target is never undershot.
+	MAX_MP3_KBPS = 320
+
+	// Sources without a buffer target still download this far ahead, so the
+	// voice send loop never waits on the network itself.
+	MIN_READAHEAD_MS = 500
+
+	READAHEAD_CHUNK_SIZE = 16 * 1024
+)
+
+func ValidateBufferMs(bufferMs int) error {
+	if bufferMs < 0 || bufferMs > MAX_BUFFER_MS {
+		return fmt.Errorf("buffer_ms must be between 0 and %d", MAX_BUFFER_MS)
+	}
+	return nil
+}
+
+func bufferBytes(ms, kbps int) int {
+	return ms * kbps / BITS_PER_BYTE
+}
+
+// readahead keeps downloading the compressed stream in the background up to a
+// limit, so a slow read from the origin doesn't stall the 20ms frame loop.
+type readahead struct {
+	src   io.ReadCloser
+	mutex sync.Mutex
+	cond  *sync.Cond
+	buf   []byte
+	limit int
+	err   error
+
+	counters *counters
+}
+
+func newReadahead(src io.ReadCloser, limit int, counters *counters) *readahead {
+	r := &readahead{src: src, limit: limit, counters: counters}
+	r.cond = sync.NewCond(&r.mutex)
+	go r.fill()
+	return r
+}
+
+func (r *readahead) fill() {
+	chunk := make([]byte, READAHEAD_CHUNK_SIZE)
+	for {
+		r.mutex.Lock()
+		for len(r.buf) >= r.limit && r.err == nil {
+			r.cond.Wait()
+		}
+		if r.err != nil {
+			r.mutex.Unlock()
+			return
+		}
+		r.mutex.Unlock()
+
+		n, err := r.src.Read(chunk)
+
+		r.mutex.Lock()
+		if r.err == nil {
+			r.buf = append(r.buf, chunk[:n]...)
+			r.err = err
+			// Close sets err first, so this is the origin breaking off the
+			// response, like a reset or a body shorter than announced.
+			if err != nil && err != io.EOF {
+				r.counters.partialReads.Add(1)
+			}
+		}
+		r.cond.Broadcast()
+		r.mutex.Unlock()
+	}
+}
+
+func (r *readahead) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.buf) == 0 && r.err == nil {
+		r.counters.underruns.Add(1)
+	}
+	for len(r.buf) == 0 && r.err == nil {
+		r.cond.Wait()
+	}
+
+	if len(r.buf) == 0 {
+		return 0, r.err
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.cond.Broadcast()
+	return n, nil
+}
+
+func (r *readahead) Close() error {
+	r.mutex.Lock()
+	if r.err == nil {
+		r.err = io.ErrClosedPipe
+	}
+	r.buf = nil
+	r.cond.Broadcast()
+	r.mutex.Unlock()
+
+	return r.src.Close()
+}
+
+func (r *readahead) setLimit(limit int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.limit = limit
+	r.cond.Broadcast()
+}
+
+// full is also set once the stream is downloaded completely, as the buffer
+// can't grow any further. limit is the most the buffer holds.
+func (r *readahead) state() (buffered, limit int, full bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.buf), r.limit, len(r.buf) >= r.limit || r.err != nil
+}
@@ -0,0 +1,66 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SourceFactory creates the source for a URL, DefaultFactory handles the
+// built-in schemes.
+type SourceFactory interface {
+	CreateFromURL(ctx context.Context, url string, opts Options) (Source, error)
+}
+
+var (
+	factories   = map[string]SourceFactory{}
+	factoriesMu sync.RWMutex
+)
+
+// RegisterFactory routes URLs of scheme to factory, ahead of the built-in
+// schemes, so an embedding program can play from its own backends. The factory
+// is responsible for validating the URLs it accepts.
+func RegisterFactory(scheme string, factory SourceFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[strings.ToLower(scheme)] = factory
+}
+
+func UnregisterFactory(scheme string) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	delete(factories, strings.ToLower(scheme))
+}
+
+func registeredFactory(url string) SourceFactory {
+	scheme, _, ok := strings.Cut(url, ":")
+	if !ok {
+		return nil
+	}
+
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	return factories[strings.ToLower(scheme)]
+}
+
+func registeredSchemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	return slices.Sorted(maps.Keys(factories))
+}
+
+// ReaderFactory adapts a function that opens a URL's MP3 bytes into a
+// SourceFactory, for backends like object stores that only hand out readers.
+type ReaderFactory func(ctx context.Context, url string) (io.ReadCloser, error)
+
+func (f ReaderFactory) CreateFromURL(ctx context.Context, url string, opts Options) (Source, error) {
+	reader, err := f(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", RedactURL(url), err)
+	}
+	return NewMP3SourceFromReader(reader, url, opts)
+}
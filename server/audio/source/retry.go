@@ -0,0 +1,117 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	DEFAULT_CONNECT_RETRIES    = 2
+	DEFAULT_CONNECT_BACKOFF_MS = 250
+	MAX_CONNECT_BACKOFF        = 5 * time.Second
+)
+
+// ErrNetwork is returned once an origin kept failing to respond, as opposed to
+// rejecting the request.
+var ErrNetwork = errors.New("network_error")
+
+// doWithRetry only covers getting a response, a stream that breaks later is
+// not retried here. Transient network errors, 5xx and 429 are retried with
+// exponential backoff, or after the Retry-After the origin asked for. Any
+// other status is returned to the caller. Every retry is counted in reconnects.
+func doWithRetry(ctx context.Context, client *http.Client, reconnects *atomic.Int64, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	cfg := GetConfig()
+	backoff := time.Duration(cfg.ConnectBackoffMs) * time.Millisecond
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+
+		wait := backoff
+		resp, err := client.Do(req)
+		switch {
+		case err != nil:
+			lastErr = fmt.Errorf("fetch audio: %w", err)
+			if ctx.Err() == nil && !transient(err) {
+				return nil, fmt.Errorf("%w: %w", ErrNetwork, lastErr)
+			}
+		case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests:
+			resp.Body.Close()
+			lastErr = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+			if after, ok := retryAfter(resp.Header, time.Now()); ok {
+				wait = min(after, MAX_CONNECT_BACKOFF)
+			}
+		default:
+			return resp, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, lastErr
+		}
+		if attempt >= cfg.ConnectRetries {
+			return nil, fmt.Errorf("%w: %d attempts failed: %w", ErrNetwork, attempt+1, lastErr)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, lastErr
+		}
+		backoff = min(backoff*2, MAX_CONNECT_BACKOFF)
+		reconnects.Add(1)
+	}
+}
+
+// transient tells errors worth another attempt, like a refused or reset
+// connection, from ones that fail the same way every time, like a bad
+// certificate or a host that doesn't resolve.
+func transient(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Closed by the origin before it answered.
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH)
+}
+
+// retryAfter reads the Retry-After of a 429 or 503, in seconds or as a date.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,102 @@
+package source
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"dns timeout", &net.DNSError{Err: "timeout", Name: "example.com", IsTimeout: true}, true},
+		{"no such host", &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, false},
+		{"bad certificate", x509.UnknownAuthorityError{}, false},
+	}
+
+	for _, tt := range tests {
+		if got := transient(tt.err); got != tt.want {
+			t.Errorf("transient(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"3":                             3 * time.Second,
+		"Thu, 01 Jan 2026 12:00:02 GMT": 2 * time.Second,
+		"Thu, 01 Jan 2026 11:00:00 GMT": 0,
+	}
+
+	for value, want := range tests {
+		header := http.Header{"Retry-After": {value}}
+		if got, ok := retryAfter(header, now); !ok || got != want {
+			t.Errorf("retryAfter(%q) = (%v, %v), want %v", value, got, ok, want)
+		}
+	}
+	if _, ok := retryAfter(http.Header{"Retry-After": {"soon"}}, now); ok {
+		t.Error("retryAfter accepted an invalid value")
+	}
+}
+
+func TestDoWithRetryHonoursRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var reconnects atomic.Int64
+	resp, err := doWithRetry(context.Background(), srv.Client(), &reconnects, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, srv.URL, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if reconnects.Load() != 1 {
+		t.Fatalf("reconnects = %d, want 1", reconnects.Load())
+	}
+}
+
+func TestDoWithRetryGivesUpOnPermanentErrors(t *testing.T) {
+	var attempts int
+	client := &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		attempts++
+		return nil, x509.UnknownAuthorityError{}
+	})}
+
+	var reconnects atomic.Int64
+	_, err := doWithRetry(context.Background(), client, &reconnects, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, "https://example.com/a.mp3", nil)
+	})
+	if !errors.Is(err, ErrNetwork) || attempts != 1 {
+		t.Fatalf("err = %v after %d attempts, want ErrNetwork after 1", err, attempts)
+	}
+}
@@ -0,0 +1,29 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hraban/opus"
+)
+
+// CheckOpus encodes a frame of silence once, so a broken libopus fails the
+// startup and the healthcheck instead of the first track.
+var CheckOpus = sync.OnceValues(func() (string, error) {
+	encoder, err := opus.NewEncoder(OPUS_SAMPLE_RATE, OPUS_CHANNELS, opus.AppAudio)
+	if err != nil {
+		return "", fmt.Errorf("create opus encoder: %w", err)
+	}
+
+	silence := make([]int16, OPUS_FRAME_SIZE*OPUS_CHANNELS)
+	n, err := encoder.Encode(silence, make([]byte, OPUS_MAX_FRAME_BYTES))
+	if err != nil {
+		return "", fmt.Errorf("encode opus: %w", err)
+	}
+	if n == 0 {
+		return "", errors.New("encode opus: encoder produced an empty frame")
+	}
+
+	return opus.Version(), nil
+})
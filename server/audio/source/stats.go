@@ -0,0 +1,43 @@
+package source
+
+import "sync/atomic"
+
+// Stats counts what went wrong while a track was read, including what was
+// recovered from, so flaky origins can be told apart from broken files.
+type Stats struct {
+	// Responses the origin broke off before their end.
+	PartialReads int64 `json:"partial_reads"`
+	// Frames that couldn't be decoded or encoded.
+	DecodeErrors int64 `json:"decode_errors"`
+	// Requests to the origin that were retried, see doWithRetry.
+	Reconnects int64 `json:"reconnects"`
+	// Times playback had to wait for the network.
+	Underruns int64 `json:"underruns"`
+}
+
+func (s Stats) Add(other Stats) Stats {
+	return Stats{
+		PartialReads: s.PartialReads + other.PartialReads,
+		DecodeErrors: s.DecodeErrors + other.DecodeErrors,
+		Reconnects:   s.Reconnects + other.Reconnects,
+		Underruns:    s.Underruns + other.Underruns,
+	}
+}
+
+// counters are shared by everything reading for one source, so counts from
+// before a seek are kept.
+type counters struct {
+	partialReads atomic.Int64
+	decodeErrors atomic.Int64
+	reconnects   atomic.Int64
+	underruns    atomic.Int64
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		PartialReads: c.partialReads.Load(),
+		DecodeErrors: c.decodeErrors.Load(),
+		Reconnects:   c.reconnects.Load(),
+		Underruns:    c.underruns.Load(),
+	}
+}
@@ -11,8 +11,6 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-
-	"github.com/shi-gg/linkdave/server/audio/filter"
 )
 
 type ttsRequestBody struct {
@@ -31,10 +29,11 @@ type ttsInvokeResponse struct {
 }
 
 var client = &http.Client{
-	Timeout: DIAL_TIMEOUT,
+	Transport: baseTransport,
+	Timeout:   DIAL_TIMEOUT,
 }
 
-func NewTTSSource(ctx context.Context, urlStr string, startTimeMs int64, filters *filter.Filters) (*MP3Source, error) {
+func NewTTSSource(ctx context.Context, urlStr string, opts Options) (*MP3Source, error) {
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, fmt.Errorf("parse url: %w", err)
@@ -52,6 +51,8 @@ func NewTTSSource(ctx context.Context, urlStr string, startTimeMs int64, filters
 		return nil, fmt.Errorf("encode req body: %w", err)
 	}
 
+	cfg := GetConfig()
+
 	req, err := http.NewRequestWithContext(ctx, "POST", cfg.TextToSpeechURL, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
@@ -98,5 +99,5 @@ func NewTTSSource(ctx context.Context, urlStr string, startTimeMs int64, filters
 	}
 
 	reader := io.NopCloser(bytes.NewReader(audioBytes))
-	return NewMP3SourceFromReader(reader, urlStr, startTimeMs, filters)
+	return NewMP3SourceFromReader(reader, urlStr, opts)
 }
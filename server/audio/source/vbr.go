@@ -0,0 +1,137 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	XING_FLAGS_OFFSET     = 4
+	XING_DATA_OFFSET      = 8
+	XING_FRAME_COUNT_SIZE = 4
+	XING_BYTE_COUNT_SIZE  = 4
+	XING_TOC_SIZE         = 100
+	XING_TOC_SCALE        = 256
+
+	XING_FLAG_FRAMES = 1 << 0
+	XING_FLAG_BYTES  = 1 << 1
+	XING_FLAG_TOC    = 1 << 2
+
+	VBRI_BYTE_COUNT_OFFSET  = 10
+	VBRI_FRAME_COUNT_OFFSET = 14
+	VBRI_MIN_SIZE           = 18
+
+	ID3V2_HEADER_SIZE = 10
+	ID3V2_FOOTER_FLAG = 0x10
+)
+
+var (
+	tagXing = []byte("Xing")
+	tagInfo = []byte("Info")
+	tagVBRI = []byte("VBRI")
+	tagID3  = []byte("ID3")
+)
+
+type vbrHeader struct {
+	frames int64
+	bytes  int64
+	toc    []byte
+}
+
+func parseVBRHeader(data []byte) vbrHeader {
+	for _, tag := range [2][]byte{tagXing, tagInfo} {
+		idx := bytes.Index(data, tag)
+		if idx < 0 || idx+XING_DATA_OFFSET > len(data) {
+			continue
+		}
+
+		if header, ok := parseXingHeader(data[idx:]); ok {
+			return header
+		}
+	}
+
+	if idx := bytes.Index(data, tagVBRI); idx >= 0 && idx+VBRI_MIN_SIZE <= len(data) {
+		return vbrHeader{
+			frames: int64(binary.BigEndian.Uint32(data[idx+VBRI_FRAME_COUNT_OFFSET:])),
+			bytes:  int64(binary.BigEndian.Uint32(data[idx+VBRI_BYTE_COUNT_OFFSET:])),
+		}
+	}
+
+	return vbrHeader{}
+}
+
+// The Xing fields are optional and packed in flag order, so each one is only
+// present (and shifts the following ones) when its flag is set.
+func parseXingHeader(data []byte) (vbrHeader, bool) {
+	var header vbrHeader
+
+	flags := binary.BigEndian.Uint32(data[XING_FLAGS_OFFSET:])
+	offset := XING_DATA_OFFSET
+
+	if flags&XING_FLAG_FRAMES == 0 {
+		return header, false
+	}
+	if offset+XING_FRAME_COUNT_SIZE > len(data) {
+		return header, false
+	}
+	header.frames = int64(binary.BigEndian.Uint32(data[offset:]))
+	offset += XING_FRAME_COUNT_SIZE
+
+	if flags&XING_FLAG_BYTES != 0 {
+		if offset+XING_BYTE_COUNT_SIZE > len(data) {
+			return header, true
+		}
+		header.bytes = int64(binary.BigEndian.Uint32(data[offset:]))
+		offset += XING_BYTE_COUNT_SIZE
+	}
+
+	if flags&XING_FLAG_TOC != 0 && offset+XING_TOC_SIZE <= len(data) {
+		header.toc = bytes.Clone(data[offset : offset+XING_TOC_SIZE])
+	}
+
+	return header, true
+}
+
+func id3v2Size(data []byte) int64 {
+	if len(data) < ID3V2_HEADER_SIZE || !bytes.HasPrefix(data, tagID3) {
+		return 0
+	}
+
+	// ID3v2 sizes are syncsafe integers: 7 significant bits per byte.
+	size := int64(data[6])<<21 | int64(data[7])<<14 | int64(data[8])<<7 | int64(data[9])
+	size += ID3V2_HEADER_SIZE
+	if data[5]&ID3V2_FOOTER_FLAG != 0 {
+		size += ID3V2_HEADER_SIZE
+	}
+
+	return size
+}
+
+type mp3Seeker struct {
+	open       func(ctx context.Context, offset int64) (io.ReadCloser, error)
+	audioStart int64
+	audioBytes int64
+	toc        []byte
+}
+
+// Without a TOC the stream is assumed to be CBR, where time maps linearly to bytes.
+func (s *mp3Seeker) offset(positionMs, durationMs int64) int64 {
+	fraction := float64(positionMs) / float64(durationMs)
+	if len(s.toc) != XING_TOC_SIZE {
+		return s.audioStart + int64(fraction*float64(s.audioBytes))
+	}
+
+	percent := min(fraction*XING_TOC_SIZE, XING_TOC_SIZE-1)
+	i := int(percent)
+
+	lower := float64(s.toc[i])
+	upper := float64(XING_TOC_SCALE)
+	if i+1 < XING_TOC_SIZE {
+		upper = float64(s.toc[i+1])
+	}
+
+	scaled := lower + (upper-lower)*(percent-float64(i))
+	return s.audioStart + int64(scaled/XING_TOC_SCALE*float64(s.audioBytes))
+}
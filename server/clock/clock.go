@@ -0,0 +1,46 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is injected where positions are derived from wall time, so tests can
+// advance it instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var SYSTEM Clock = systemClock{}
+
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Manual only moves when advanced.
+type Manual struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewManual(now time.Time) *Manual {
+	return &Manual{now: now}
+}
+
+func (m *Manual) Now() time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.now
+}
+
+func (m *Manual) Advance(d time.Duration) {
+	m.mutex.Lock()
+	m.now = m.now.Add(d)
+	m.mutex.Unlock()
+}
@@ -1,8 +1,13 @@
 package protocol
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
 	"github.com/disgoorg/snowflake/v2"
 	"github.com/shi-gg/linkdave/server/audio/filter"
+	"github.com/shi-gg/linkdave/server/audio/source"
 )
 
 type Message struct {
@@ -28,6 +33,26 @@ type VoiceUpdateData struct {
 	Event     VoiceServerEvent `json:"event"`
 }
 
+func (v *VoiceUpdateData) Validate() error {
+	switch {
+	case v.ClientID == 0:
+		return missingField("client_id")
+	case v.GuildID == 0:
+		return missingField("guild_id")
+	case v.ChannelID == 0:
+		return missingField("channel_id")
+	case v.SessionID == "":
+		return missingField("session_id")
+	case v.Event.Token == "":
+		return missingField("event.token")
+	case v.Event.Endpoint == "":
+		return missingField("event.endpoint")
+	case v.Event.GuildID != "" && v.Event.GuildID != v.GuildID.String():
+		return &FieldError{Field: "event.guild_id", Reason: "doesn't match guild_id"}
+	}
+	return nil
+}
+
 type PlayData struct {
 	GuildID   snowflake.ID `json:"guild_id"`
 	URL       string       `json:"url"`
@@ -44,13 +69,40 @@ type SeekData struct {
 }
 
 type PlayerUpdateData struct {
-	GuildID snowflake.ID `json:"guild_id"`
-	State   string       `json:"state"`
+	GuildID   snowflake.ID `json:"guild_id"`
+	State     string       `json:"state"`
+	QueueSize int          `json:"queue_size"`
+	// Omitted when the node doesn't limit queues.
+	MaxQueueSize int `json:"max_queue_size,omitempty"`
+	// Unix milliseconds, omitted unless paused.
+	PausedSince int64 `json:"paused_since,omitempty"`
+	// How long the current track was paused in total.
+	PausedMs int64 `json:"paused_ms,omitempty"`
+	// Position is exact at Timestamp, the node's unix milliseconds. Clients
+	// interpolate from there while playing, after offsetting their own clock.
+	Position  int64 `json:"position"`
+	Timestamp int64 `json:"timestamp"`
+	// Round trip between the node and this client from ping and pong,
+	// omitted until the client answered a ping.
+	Latency int64 `json:"latency_ms,omitempty"`
 }
 
 type ReadyData struct {
-	SessionID string `json:"session_id"`
-	Resumed   bool   `json:"resumed"`
+	SessionID    string       `json:"session_id"`
+	Resumed      bool         `json:"resumed"`
+	Node         string       `json:"node,omitempty"`
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+type Capabilities struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Sources         []string `json:"sources"`
+	Filters         []string `json:"filters"`
+	Features        []string `json:"features"`
+	// Client ops the node handles, others are answered with ErrUnknownOp.
+	Ops []int `json:"ops"`
+	// Most tracks one queue add may carry, omitted when there's no limit.
+	MaxQueueAdd int `json:"max_queue_add_items,omitempty"`
 }
 
 type TrackInfo struct {
@@ -58,6 +110,8 @@ type TrackInfo struct {
 	Title       string `json:"title,omitempty"`
 	Duration    int64  `json:"duration,omitempty"`
 	RequesterID string `json:"requester_id,omitempty"`
+	// Bitrate the track is encoded with, only set on track start.
+	Bitrate int `json:"bitrate,omitempty"`
 }
 
 type TrackStartData struct {
@@ -69,6 +123,8 @@ type TrackEndData struct {
 	GuildID snowflake.ID `json:"guild_id"`
 	Track   TrackInfo    `json:"track"`
 	Reason  string       `json:"reason"`
+	// What went wrong while reading the track, including what was recovered from.
+	Stats source.Stats `json:"stats"`
 }
 
 type TrackErrorData struct {
@@ -82,16 +138,148 @@ type VoiceConnectData struct {
 	ChannelID snowflake.ID `json:"channel_id"`
 }
 
+type VoiceReadyData struct {
+	GuildID snowflake.ID `json:"guild_id"`
+}
+
+type VoiceQueuedData struct {
+	GuildID snowflake.ID `json:"guild_id"`
+	// 1-based, how many voice updates are set up before this one.
+	Position int `json:"position"`
+}
+
 type VoiceDisconnectData struct {
 	GuildID snowflake.ID `json:"guild_id"`
 	Reason  string       `json:"reason,omitempty"`
 }
 
 type StatsData struct {
-	Players       int    `json:"players"`
-	PlayingTracks int    `json:"playing_tracks"`
-	Uptime        int64  `json:"uptime"`
-	Memory        uint64 `json:"memory"`
+	Node          string    `json:"node,omitempty"`
+	Players       int       `json:"players"`
+	PlayingTracks int       `json:"playing_tracks"`
+	Uptime        int64     `json:"uptime"`
+	Memory        uint64    `json:"memory"`
+	Bandwidth     Bandwidth `json:"bandwidth"`
+}
+
+type Bandwidth struct {
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	SentBytes       int64 `json:"sent_bytes"`
+}
+
+func (b Bandwidth) Add(other Bandwidth) Bandwidth {
+	return Bandwidth{
+		DownloadedBytes: b.DownloadedBytes + other.DownloadedBytes,
+		SentBytes:       b.SentBytes + other.SentBytes,
+	}
+}
+
+type GuildStats struct {
+	GuildID   snowflake.ID `json:"guild_id"`
+	Bandwidth Bandwidth    `json:"bandwidth"`
+	Healthy   bool         `json:"healthy"`
+	Pacing    *PacingStats `json:"pacing,omitempty"`
+	Buffer    *BufferStats `json:"buffer,omitempty"`
+	// Reconnects of an unhealthy voice connection within the last minute.
+	Reconnects int `json:"reconnects"`
+}
+
+// BufferStats compare how much of the current track is downloaded ahead of
+// playback with the target it fills up to, the player's or the minimum
+// readahead.
+type BufferStats struct {
+	BufferedMs    int `json:"buffered_ms"`
+	TargetMs      int `json:"target_ms"`
+	BufferedBytes int `json:"buffered_bytes"`
+	LimitBytes    int `json:"limit_bytes"`
+}
+
+// BufferTotals sum what the readaheads of the tracks playing right now hold in
+// memory and the most they may hold.
+type BufferTotals struct {
+	Sources       int   `json:"sources"`
+	BufferedBytes int64 `json:"buffered_bytes"`
+	LimitBytes    int64 `json:"limit_bytes"`
+}
+
+// PacingStats describe the last window of frame requests by the voice
+// transport, which should arrive every 20ms.
+type PacingStats struct {
+	MeanIntervalMs float64 `json:"mean_interval_ms"`
+	JitterMs       float64 `json:"jitter_ms"`
+	DriftMs        float64 `json:"drift_ms"`
+}
+
+type ClientStats struct {
+	SessionID string       `json:"session_id"`
+	Name      string       `json:"name"`
+	Addr      string       `json:"addr"`
+	Bandwidth Bandwidth    `json:"bandwidth"`
+	Guilds    []GuildStats `json:"guilds"`
+	// Smoothed round trip in milliseconds, 0 until the client answered a ping.
+	Latency int64 `json:"latency_ms"`
+}
+
+// ConnectionInfo is a snapshot of one voice connection, for correlating user
+// reports with what the node is actually doing.
+type ConnectionInfo struct {
+	SessionID string       `json:"session_id"`
+	GuildID   snowflake.ID `json:"guild_id"`
+	ChannelID snowflake.ID `json:"channel_id"`
+	State     string       `json:"state"`
+	Healthy   bool         `json:"healthy"`
+	Playback  string       `json:"playback"`
+	URL       string       `json:"url,omitempty"`
+	Position  int64        `json:"position"`
+	UptimeMs  int64        `json:"uptime_ms"`
+	Recording bool         `json:"recording"`
+}
+
+// NodeSnapshot is the full state of a node at one instant, for backups before
+// risky deploys.
+type NodeSnapshot struct {
+	Node    string `json:"node,omitempty"`
+	Version string `json:"version"`
+	// Unix milliseconds, player positions are exact at this instant.
+	Timestamp   int64            `json:"timestamp"`
+	Clients     []ClientSnapshot `json:"clients"`
+	Connections []ConnectionInfo `json:"connections"`
+	// Queues were cut short to keep the snapshot bounded.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type ClientSnapshot struct {
+	SessionID string           `json:"session_id"`
+	Name      string           `json:"name"`
+	Addr      string           `json:"addr"`
+	Players   []PlayerSnapshot `json:"players"`
+}
+
+type PlayerSnapshot struct {
+	GuildID     snowflake.ID    `json:"guild_id"`
+	ChannelID   snowflake.ID    `json:"channel_id"`
+	State       string          `json:"state"`
+	URL         string          `json:"url,omitempty"`
+	Title       string          `json:"title,omitempty"`
+	RequesterID string          `json:"requester_id,omitempty"`
+	Position    int64           `json:"position"`
+	Volume      int             `json:"volume"`
+	Filters     *filter.Filters `json:"filters,omitempty"`
+	Queue       []QueueItem     `json:"queue"`
+	// Waiting out the player grace period for its voice connection to return.
+	Detached bool `json:"detached,omitempty"`
+}
+
+type VoiceHealthData struct {
+	GuildID snowflake.ID `json:"guild_id"`
+	Healthy bool         `json:"healthy"`
+}
+
+// BufferingData is sent when playback holds back to refill the buffer after
+// repeated underruns, and again once it resumes.
+type BufferingData struct {
+	GuildID   snowflake.ID `json:"guild_id"`
+	Buffering bool         `json:"buffering"`
 }
 
 type NodeDrainingData struct {
@@ -103,6 +291,40 @@ type PlayerMigrateData struct {
 	GuildID snowflake.ID `json:"guild_id"`
 }
 
+func (p *PlayerMigrateData) Validate() error {
+	if p.GuildID == 0 {
+		return missingField("guild_id")
+	}
+	return nil
+}
+
+// ErrorData answers a client op that was rejected. Field is the offending
+// payload field, empty when the payload as a whole couldn't be read.
+type ErrorData struct {
+	Op    uint8  `json:"op"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// ErrUnknownOp answers an op the node doesn't know, usually one from a newer
+// client, which would otherwise be silently ignored.
+var ErrUnknownOp = errors.New("unknown_op")
+
+// FieldError names the payload field that failed validation, so clients
+// learn what to fix instead of acting on zero values.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+func missingField(field string) error {
+	return &FieldError{Field: field, Reason: "is required"}
+}
+
 type MigrateReadyData struct {
 	GuildID     snowflake.ID    `json:"guild_id"`
 	URL         string          `json:"url"`
@@ -110,23 +332,223 @@ type MigrateReadyData struct {
 	State       string          `json:"state"`
 	RequesterID string          `json:"requester_id,omitempty"`
 	Filters     *filter.Filters `json:"filters,omitempty"`
+	Volume      int             `json:"volume"`
 }
 
 type StatsResponse struct {
-	Version      string `json:"version"`
-	Runtime      string `json:"runtime"`
-	Uptime       int64  `json:"uptime_ms"`
-	NumGoroutine int    `json:"num_goroutines"`
-	Memory       uint64 `json:"memory"`
+	Node         string    `json:"node,omitempty"`
+	Version      string    `json:"version"`
+	Runtime      string    `json:"runtime"`
+	Uptime       int64     `json:"uptime_ms"`
+	NumGoroutine int       `json:"num_goroutines"`
+	Memory       uint64    `json:"memory"`
+	Bandwidth    Bandwidth `json:"bandwidth"`
+	// Guilds that hit the reconnect limit and can't connect until their cooldown ends.
+	OpenCircuitBreakers int `json:"open_circuit_breakers"`
+	// Voice updates waiting for the connect concurrency limit.
+	QueuedConnects int `json:"queued_connects"`
+	// Summed over every track played on this node.
+	Sources source.Stats `json:"sources"`
+	Buffers BufferTotals `json:"buffers"`
+	// Over the memory limit, new players and plays are refused.
+	Overloaded bool `json:"overloaded"`
 }
 
-type RequestPlay struct {
-	URL         string          `json:"url"`
-	StartTime   int64           `json:"start_time,omitempty"`
+type QueueItem struct {
+	URL       string `json:"url"`
+	StartTime int64  `json:"start_time,omitempty"`
+	// Title is a human readable label echoed in events and logs, it doesn't
+	// affect playback.
+	Title       string          `json:"title,omitempty"`
 	RequesterID string          `json:"requester_id,omitempty"`
 	Filters     *filter.Filters `json:"filters,omitempty"`
+	Normalize   *bool           `json:"normalize,omitempty"`
+	// Bitrate in bits per second overrides the node's opus bitrate for this track.
+	Bitrate *int `json:"bitrate,omitempty"`
+	// Signal is auto, music or voice, TTS tracks sound clearer as voice.
+	Signal string `json:"signal,omitempty"`
+}
+
+func (q *QueueItem) Validate() error {
+	if q.Bitrate != nil {
+		if err := source.ValidateBitrate(*q.Bitrate); err != nil {
+			return err
+		}
+	}
+	if err := source.ValidateSignal(q.Signal); err != nil {
+		return err
+	}
+	return q.Filters.Validate()
+}
+
+type QueueUpdateData struct {
+	GuildID snowflake.ID `json:"guild_id"`
+	Queue   []QueueItem  `json:"queue"`
+	// Omitted when the node doesn't limit queues.
+	MaxSize int `json:"max_size,omitempty"`
+}
+
+// What a play does while the player already has a track.
+const (
+	PlayModeReplace = "replace"
+	PlayModeEnqueue = "enqueue"
+)
+
+func ValidatePlayMode(mode string) error {
+	switch mode {
+	case "", PlayModeReplace, PlayModeEnqueue:
+		return nil
+	}
+	return fmt.Errorf("mode must be %q or %q", PlayModeReplace, PlayModeEnqueue)
+}
+
+type RequestPlay struct {
+	QueueItem
+	ConnectTimeout int64 `json:"connect_timeout,omitempty"`
+	// Defaults to the session's play mode, which defaults to replace.
+	Mode string `json:"mode,omitempty"`
+}
+
+func (r *RequestPlay) Validate() error {
+	if err := ValidatePlayMode(r.Mode); err != nil {
+		return &FieldError{Field: "mode", Reason: err.Error()}
+	}
+	return r.QueueItem.Validate()
+}
+
+// PlayResponse is only sent when the play was queued, a play that started the
+// track answers 204, or with a SeekResponse when it had a start_time.
+type PlayResponse struct {
+	Mode          string `json:"mode"`
+	QueuePosition int    `json:"queue_position"`
+}
+
+type RequestQueueAdd struct {
+	Items []QueueItem `json:"items"`
+}
+
+// RequestUpdatePlayer fields that are omitted are left unchanged. With a track
+// the position is its start time, otherwise the current track seeks to it.
+type RequestUpdatePlayer struct {
+	Track    *QueueItem      `json:"track"`
+	Position *int64          `json:"position"`
+	Paused   *bool           `json:"paused"`
+	Volume   *int            `json:"volume"`
+	Filters  *filter.Filters `json:"filters"`
+
+	// VolumeRampMs glides to the new volume instead of jumping, which clicks
+	// on big changes.
+	VolumeRampMs int `json:"volume_ramp_ms,omitempty"`
+
+	// BufferMs applies from the next track on.
+	BufferMs *int `json:"buffer_ms"`
+}
+
+func (r *RequestUpdatePlayer) Validate() error {
+	if r.BufferMs != nil {
+		if err := source.ValidateBufferMs(*r.BufferMs); err != nil {
+			return err
+		}
+	}
+	if r.Volume != nil {
+		if err := filter.ValidateVolume(*r.Volume); err != nil {
+			return err
+		}
+	}
+	if err := filter.ValidateVolumeRamp(r.VolumeRampMs); err != nil {
+		return err
+	}
+	if r.VolumeRampMs > 0 && r.Volume == nil {
+		return errors.New("volume_ramp_ms requires a volume")
+	}
+	if r.Track != nil {
+		if err := r.Track.Validate(); err != nil {
+			return err
+		}
+	}
+	return r.Filters.Validate()
+}
+
+type RequestQueueMove struct {
+	From int `json:"from"`
+	To   int `json:"to"`
 }
 
 type RequestSeek struct {
 	Position int64 `json:"position"`
 }
+
+// RequestSeekRelative moves by Delta milliseconds from wherever playback is
+// when the request arrives, negative values seek backwards.
+type RequestSeekRelative struct {
+	Delta int64 `json:"delta"`
+}
+
+// RequestReload reconnects to the playing track's URL, at the current
+// position unless FromStart is set. The body may be omitted.
+type RequestReload struct {
+	FromStart bool `json:"from_start"`
+}
+
+// RequestSpeaking sets the speaking flags announced while the player sends
+// audio, the microphone flag is always included.
+type RequestSpeaking struct {
+	// Lowers other members' volume while the bot speaks, needs the Priority
+	// Speaker permission in the channel.
+	Priority   bool `json:"priority"`
+	Soundshare bool `json:"soundshare"`
+}
+
+type RequestBatch struct {
+	Commands []BatchCommand `json:"commands"`
+}
+
+// BatchCommand carries what the route named by Op takes as its body.
+type BatchCommand struct {
+	Op      string          `json:"op"`
+	GuildID snowflake.ID    `json:"guild_id"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// BatchResult is what the command's own route would have answered, in the
+// order of the commands.
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// SeekResponse tells where playback landed, which can be short of the
+// requested position as tracks can only start at a frame, and streams not at
+// all. Delta is Position minus Requested.
+type SeekResponse struct {
+	Position  int64 `json:"position"`
+	Requested int64 `json:"requested"`
+	Delta     int64 `json:"delta"`
+}
+
+func NewSeekResponse(requested, position int64) SeekResponse {
+	return SeekResponse{Position: position, Requested: requested, Delta: position - requested}
+}
+
+type SourceConfig struct {
+	HTTPEnabled             bool `json:"http_enabled"`
+	HTTPSEnabled            bool `json:"https_enabled"`
+	PublicIPAddressEnabled  bool `json:"public_ip_address_enabled"`
+	PrivateIPAddressEnabled bool `json:"private_ip_address_enabled"`
+	TextToSpeechEnabled     bool `json:"text_to_speech_enabled"`
+	DataURLEnabled          bool `json:"data_url_enabled"`
+}
+
+// Omitted fields are left unchanged.
+type RequestSourceConfig struct {
+	HTTPEnabled             *bool `json:"http_enabled"`
+	HTTPSEnabled            *bool `json:"https_enabled"`
+	PublicIPAddressEnabled  *bool `json:"public_ip_address_enabled"`
+	PrivateIPAddressEnabled *bool `json:"private_ip_address_enabled"`
+	TextToSpeechEnabled     *bool `json:"text_to_speech_enabled"`
+	DataURLEnabled          *bool `json:"data_url_enabled"`
+}
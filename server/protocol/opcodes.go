@@ -5,6 +5,10 @@ const (
 	OpPlayerMigrate uint8 = 1
 )
 
+// CLIENT_OPS are the ops this node handles, advertised in the ready payload.
+// Ints, as a []uint8 would be encoded as a base64 string.
+var CLIENT_OPS = []int{int(OpVoiceUpdate), int(OpPlayerMigrate)}
+
 const (
 	OpReady           uint8 = 0
 	OpVoiceConnect    uint8 = 1
@@ -16,6 +20,17 @@ const (
 	OpStats           uint8 = 7
 	OpNodeDraining    uint8 = 8
 	OpMigrateReady    uint8 = 9
+	OpQueueUpdate     uint8 = 10
+	OpVoiceHealth     uint8 = 11
+	OpBuffering       uint8 = 12
+	// Sent once a voice connection can send audio, after the connect and again
+	// after it had to redo its handshake.
+	OpVoiceReady uint8 = 13
+	// Sent when a client op can't be handled, as the ops have no reply.
+	OpError uint8 = 14
+	// Sent when a voice update has to wait for other connections to be set up
+	// first, the voice connect or disconnect follows once it got its turn.
+	OpVoiceQueued uint8 = 15
 )
 
 const (
@@ -23,6 +38,15 @@ const (
 	TrackEndReasonStopped  = "stopped"
 	TrackEndReasonReplaced = "replaced"
 	TrackEndReasonError    = "error"
+	// The node shut down while the track played, it can be queued on another node.
+	TrackEndReasonCleanup = "cleanup"
+)
+
+const (
+	ConnectionStateConnected  = "connected"
+	ConnectionStateConnecting = "connecting"
+	// The voice connection dropped and is waiting to be reconnected or cleaned up.
+	ConnectionStateDisconnected = "disconnected"
 )
 
 const (
@@ -35,4 +59,65 @@ const (
 	DisconnectReasonConnectionLost   = "connection_lost"
 	DisconnectReasonConnectionFailed = "connection_failed"
 	DisconnectReasonRequested        = "requested"
+	// The voice connection kept failing to reconnect, new connections for the
+	// guild are refused for a cooldown.
+	DisconnectReasonReconnectLimit = "reconnect_limit"
+	// Another session of the same bot owns the guild's voice connection.
+	DisconnectReasonSessionConflict = "session_conflict"
+	// The node is over its memory limit and takes no new players.
+	DisconnectReasonNodeOverloaded = "node_overloaded"
 )
+
+const (
+	PROTOCOL_VERSION     = 1
+	PROTOCOL_SUBPROTOCOL = "linkdave.v1"
+)
+
+const (
+	CloseUnsupportedVersion = 4000
+)
+
+// Protocol features advertised in the ready payload, so clients can detect
+// support up front instead of probing a node with requests it may not know.
+var FEATURES = []string{
+	"filters_update",
+	"equalizer_presets",
+	"seek",
+	"normalize",
+	"connect_timeout",
+	"stats_opt_out",
+	"queue",
+	"play_now",
+	"queue_shuffle",
+	"queue_move",
+	"queue_remove",
+	"queue_clear",
+	"volume",
+	"update_player",
+	"voice_health",
+	"state_updates",
+	"buffer_target",
+	"player_defaults",
+	"seek_relative",
+	"auto_rebuffer",
+	"track_bitrate",
+	"voice_ready",
+	"op_errors",
+	"queue_limit",
+	"voice_queued",
+	"volume_ramp",
+	"position_timestamp",
+	"session_conflict",
+	"speaking_flags",
+	"batch",
+	"play_mode",
+	"source_stats",
+	"client_latency",
+	"unknown_op",
+	"queue_add_limit",
+	"disconnect_all",
+	"reload",
+	"signal_hint",
+	"seek_result",
+	"memory_limit",
+}
@@ -0,0 +1,152 @@
+package recording
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	OGG_HEADER_SIZE     = 27
+	OGG_MAX_SEGMENTS    = 255
+	OGG_FLAG_BOS        = 0x02
+	OGG_FLAG_EOS        = 0x04
+	OPUS_SAMPLE_RATE    = 48000
+	OPUS_FRAME_SAMPLES  = 960
+	PACKETS_PER_PAGE    = 50
+	OGG_CRC_POLYNOMIAL  = 0x04c11db7
+	OPUS_HEAD_CHANNELS  = 2
+	OPUS_VENDOR         = "linkdave"
+	OPUS_HEAD_VERSION   = 1
+	OPUS_MAPPING_FAMILY = 0
+)
+
+var OGG_CRC_TABLE = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ OGG_CRC_POLYNOMIAL
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func oggCRC(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc = crc<<8 ^ OGG_CRC_TABLE[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// oggWriter muxes opus packets into an Ogg Opus stream (RFC 7845), so
+// recordings play in any regular player.
+type oggWriter struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+	granule  uint64
+	written  int64
+
+	segments []byte
+	data     []byte
+	packets  int
+}
+
+func newOggWriter(w io.Writer, serial uint32) (*oggWriter, error) {
+	o := &oggWriter{w: w, serial: serial}
+
+	head := make([]byte, 0, 19)
+	head = append(head, "OpusHead"...)
+	head = append(head, OPUS_HEAD_VERSION, OPUS_HEAD_CHANNELS)
+	head = binary.LittleEndian.AppendUint16(head, 0) // pre-skip
+	head = binary.LittleEndian.AppendUint32(head, OPUS_SAMPLE_RATE)
+	head = binary.LittleEndian.AppendUint16(head, 0) // output gain
+	head = append(head, OPUS_MAPPING_FAMILY)
+	if err := o.writePacketPage(head, OGG_FLAG_BOS); err != nil {
+		return nil, err
+	}
+
+	tags := make([]byte, 0, 16+len(OPUS_VENDOR))
+	tags = append(tags, "OpusTags"...)
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(OPUS_VENDOR)))
+	tags = append(tags, OPUS_VENDOR...)
+	tags = binary.LittleEndian.AppendUint32(tags, 0) // no user comments
+	if err := o.writePacketPage(tags, 0); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *oggWriter) duration() time.Duration {
+	return time.Duration(o.granule) * time.Second / OPUS_SAMPLE_RATE
+}
+
+func (o *oggWriter) writePacket(packet []byte) error {
+	lacing := len(packet)/255 + 1
+	if len(o.segments)+lacing > OGG_MAX_SEGMENTS {
+		if err := o.flush(0); err != nil {
+			return err
+		}
+	}
+
+	for range lacing - 1 {
+		o.segments = append(o.segments, 255)
+	}
+	o.segments = append(o.segments, byte(len(packet)%255))
+	o.data = append(o.data, packet...)
+	// Every voice frame is 20ms, whatever the packet encodes.
+	o.granule += OPUS_FRAME_SAMPLES
+	o.packets++
+
+	if o.packets >= PACKETS_PER_PAGE {
+		return o.flush(0)
+	}
+	return nil
+}
+
+func (o *oggWriter) close() error {
+	return o.flush(OGG_FLAG_EOS)
+}
+
+func (o *oggWriter) writePacketPage(packet []byte, flags byte) error {
+	for len(packet) >= 255 {
+		o.segments = append(o.segments, 255)
+		packet = packet[255:]
+	}
+	o.segments = append(o.segments, byte(len(packet)))
+	o.data = append(o.data, packet...)
+	return o.flush(flags)
+}
+
+func (o *oggWriter) flush(flags byte) error {
+	if len(o.segments) == 0 && flags&OGG_FLAG_EOS == 0 {
+		return nil
+	}
+
+	page := make([]byte, 0, OGG_HEADER_SIZE+len(o.segments)+len(o.data))
+	page = append(page, "OggS"...)
+	page = append(page, 0, flags)
+	page = binary.LittleEndian.AppendUint64(page, o.granule)
+	page = binary.LittleEndian.AppendUint32(page, o.serial)
+	page = binary.LittleEndian.AppendUint32(page, o.sequence)
+	page = binary.LittleEndian.AppendUint32(page, 0) // checksum, filled in below
+	page = append(page, byte(len(o.segments)))
+	page = append(page, o.segments...)
+	page = append(page, o.data...)
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(0, page))
+
+	n, err := o.w.Write(page)
+	o.written += int64(n)
+	o.sequence++
+	o.segments = o.segments[:0]
+	o.data = o.data[:0]
+	o.packets = 0
+	return err
+}
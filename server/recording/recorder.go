@@ -0,0 +1,186 @@
+package recording
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_ROTATE_BYTES    = 64 << 20
+	DEFAULT_ROTATE_DURATION = time.Hour
+
+	// 10s of frames, enough to ride out a slow disk without ever making the
+	// audio sender wait.
+	FRAME_QUEUE_SIZE = 500
+)
+
+// Config is the node-wide recording setup, recording is disabled without a
+// directory.
+type Config struct {
+	Dir            string
+	RotateBytes    int64
+	RotateDuration time.Duration
+}
+
+func (c Config) Enabled() bool {
+	return c.Dir != ""
+}
+
+func (c Config) withDefaults() Config {
+	if c.RotateBytes <= 0 {
+		c.RotateBytes = DEFAULT_ROTATE_BYTES
+	}
+	if c.RotateDuration <= 0 {
+		c.RotateDuration = DEFAULT_ROTATE_DURATION
+	}
+	return c
+}
+
+// Recorder writes a connection's outgoing opus frames to rotating Ogg Opus
+// files. Frames are handed to a background writer, so disk I/O never delays
+// playback, frames are dropped instead when the writer falls behind.
+type Recorder struct {
+	logger *slog.Logger
+	config Config
+	prefix string
+
+	frames  chan []byte
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Int64
+
+	file *os.File
+	buf  *bufio.Writer
+	ogg  *oggWriter
+}
+
+// New starts a recorder whose files are named after prefix, files are only
+// created once the first frame arrives.
+func New(logger *slog.Logger, config Config, prefix string) *Recorder {
+	r := &Recorder{
+		logger: logger,
+		config: config.withDefaults(),
+		prefix: prefix,
+		frames: make(chan []byte, FRAME_QUEUE_SIZE),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Write never blocks, the frame is copied as sources reuse their buffers.
+func (r *Recorder) Write(frame []byte) {
+	select {
+	case r.frames <- slices.Clone(frame):
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Close finishes the current file and waits for it to be written.
+func (r *Recorder) Close() {
+	r.once.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	defer r.closeFile()
+
+	for {
+		select {
+		case frame := <-r.frames:
+			if !r.write(frame) {
+				return
+			}
+		case <-r.stop:
+			for {
+				select {
+				case frame := <-r.frames:
+					if !r.write(frame) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write reports whether recording can go on, a failing disk ends it instead
+// of retrying for every frame.
+func (r *Recorder) write(frame []byte) bool {
+	if r.ogg == nil {
+		if err := r.openFile(); err != nil {
+			r.logger.Error("failed to start recording file", slog.Any("error", err))
+			return false
+		}
+	}
+
+	if err := r.ogg.writePacket(frame); err != nil {
+		r.logger.Error("failed to write recording", slog.Any("error", err))
+		return false
+	}
+
+	if r.ogg.written >= r.config.RotateBytes || r.ogg.duration() >= r.config.RotateDuration {
+		r.closeFile()
+	}
+	return true
+}
+
+func (r *Recorder) openFile() error {
+	name := fmt.Sprintf("%s_%s.opus", r.prefix, time.Now().UTC().Format("20060102T150405.000Z"))
+	// Only the node's user may read recordings, they contain whatever was
+	// played into the channel.
+	file, err := os.OpenFile(filepath.Join(r.config.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(file)
+	ogg, err := newOggWriter(buf, rand.Uint32())
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file, r.buf, r.ogg = file, buf, ogg
+	r.logger.Debug("recording file opened", slog.String("file", file.Name()))
+	return nil
+}
+
+func (r *Recorder) closeFile() {
+	if r.ogg == nil {
+		return
+	}
+
+	err := r.ogg.close()
+	if err == nil {
+		err = r.buf.Flush()
+	}
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		r.logger.Error("failed to finish recording file", slog.String("file", r.file.Name()), slog.Any("error", err))
+	}
+
+	if dropped := r.dropped.Swap(0); dropped > 0 {
+		r.logger.Warn("recording fell behind, frames were dropped",
+			slog.String("file", r.file.Name()),
+			slog.Int64("dropped", dropped),
+		)
+	}
+	r.file, r.buf, r.ogg = nil, nil, nil
+}
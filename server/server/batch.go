@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+const (
+	MAX_BATCH_COMMANDS = 100
+	// Each command is limited by its route again, this bounds the batch
+	// before any of it is decoded.
+	MAX_BATCH_BODY_BYTES = 8 << 20
+	// Stays below the HTTP server's write timeout, like MAX_CONNECT_TIMEOUT, so
+	// the results of the commands that did run can still be sent.
+	BATCH_TIMEOUT = 12 * time.Second
+)
+
+// batchHandler maps batch ops to the routes they stand for, only routes that
+// take everything from their body can be batched.
+func (s *Server) batchHandler(op string) sessionHandler {
+	switch op {
+	case "play":
+		return s.routePlay
+	case "play_now":
+		return s.routePlayNow
+	case "reload":
+		return s.routeReload
+	case "pause":
+		return s.routePause
+	case "resume":
+		return s.routeResume
+	case "stop":
+		return s.routeStop
+	case "seek":
+		return s.routeSeek
+	case "seek_relative":
+		return s.routeSeekRelative
+	case "filters":
+		return s.routeFilters
+	case "speaking":
+		return s.routeSpeaking
+	case "update":
+		return s.routeUpdatePlayer
+	case "queue_add":
+		return s.routeQueueAdd
+	case "queue_clear":
+		return s.routeQueueClear
+	case "queue_skip":
+		return s.routeQueueSkip
+	case "queue_shuffle":
+		return s.routeQueueShuffle
+	case "queue_move":
+		return s.routeQueueMove
+	case "disconnect":
+		return s.routeDisconnect
+	}
+	return nil
+}
+
+// routeBatch runs commands one after another through their routes, so a
+// client restoring many players needs one request instead of one per command.
+// A failed command doesn't stop the ones after it.
+func (s *Server) routeBatch(client *Client, w http.ResponseWriter, r *http.Request) {
+	var batch protocol.RequestBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, max(MAX_BATCH_BODY_BYTES, trackBodyLimit()))).Decode(&batch); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(batch.Commands) > MAX_BATCH_COMMANDS {
+		writeJSON(w, http.StatusRequestEntityTooLarge, protocol.ErrorResponse{Error: "too many commands in batch"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), BATCH_TIMEOUT)
+	defer cancel()
+
+	response := protocol.BatchResponse{Results: make([]protocol.BatchResult, 0, len(batch.Commands))}
+	for _, command := range batch.Commands {
+		response.Results = append(response.Results, s.runBatchCommand(ctx, client, r, command))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) runBatchCommand(ctx context.Context, client *Client, r *http.Request, command protocol.BatchCommand) protocol.BatchResult {
+	rec := &batchRecorder{header: make(http.Header)}
+
+	handler := s.batchHandler(command.Op)
+	switch {
+	case handler == nil:
+		writeJSON(rec, http.StatusBadRequest, protocol.ErrorResponse{Error: "unknown op: " + command.Op})
+	case command.GuildID == 0:
+		writeJSON(rec, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid guild_id"})
+	case ctx.Err() != nil:
+		writeJSON(rec, http.StatusGatewayTimeout, protocol.ErrorResponse{Error: "batch timed out before the command ran"})
+	default:
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL.Path, bytes.NewReader(command.Data))
+		if err != nil {
+			writeJSON(rec, http.StatusInternalServerError, protocol.ErrorResponse{Error: err.Error()})
+			break
+		}
+		handler(client, command.GuildID, rec, req)
+	}
+
+	// A route that wrote nothing answered like net/http would.
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	result := protocol.BatchResult{Status: rec.status}
+	if rec.body.Len() > 0 {
+		result.Body = rec.body.Bytes()
+	}
+	return result
+}
+
+// batchRecorder collects what a route answers for one batched command.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *batchRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *batchRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *batchRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
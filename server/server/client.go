@@ -1,8 +1,14 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
 	"sync"
 	"time"
 
@@ -10,51 +16,114 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/shi-gg/linkdave/server/audio/filter"
+	"github.com/shi-gg/linkdave/server/audio/source"
+	"github.com/shi-gg/linkdave/server/clock"
 	"github.com/shi-gg/linkdave/server/protocol"
 )
 
 const (
-	WRITE_TIMEOUT    = 10 * time.Second
-	PONG_TIMEOUT     = 60 * time.Second
-	PING_PERIOD      = (PONG_TIMEOUT * 9) / 10
 	MAX_MESSAGE_SIZE = 512 * 1024 // 512KB
+
+	// Bounds how long a closing client may flush queued events, so a peer that's
+	// already gone can't hold up shutdown.
+	CLOSE_DRAIN_TIMEOUT = 2 * time.Second
+
+	// Voice updates a client can send ahead of the one being connected.
+	VOICE_UPDATE_QUEUE_SIZE = 64
+
+	DEFAULT_MAX_QUEUE_LENGTH = 1000
 )
 
+var ErrQueueFull = errors.New("queue is full")
+
 type Player struct {
-	mutex       sync.RWMutex
-	guildID     snowflake.ID
-	channelID   snowflake.ID
-	state       string
-	currentURL  string
-	position    int64
-	startedAt   time.Time
-	requesterID string
+	mutex     sync.RWMutex
+	guildID   snowflake.ID
+	channelID snowflake.ID
+	state     string
+	// The item the track was started from, so resuming or reloading it
+	// replays every setting it had. Zero while idle.
+	current   protocol.QueueItem
+	position  int64
+	startedAt time.Time
+	// Zero unless paused.
+	pausedAt time.Time
+	// Time the current track spent paused before pausedAt.
+	pausedTotal time.Duration
 	filters     *filter.Filters
+	// Filters set while idle, for the next track that brings none.
+	nextFilters *filter.Filters
+	volume      int
+	bufferMs    *int
+	queue       []protocol.QueueItem
+	// 0 for no limit.
+	maxQueue    int
+	clock       clock.Clock
+	pendingPlay *pendingPlay
+	// The latest play until it returns, even once canceled, see beginPlay.
+	settingUp *pendingPlay
+}
+
+// pendingPlay is a play whose source is still being fetched.
+type pendingPlay struct {
+	cancel context.CancelFunc
+	// Detaches the play from the context bounding its setup.
+	stopSetup func() bool
+	// The play before, when this one gave up waiting for it.
+	waitsFor *pendingPlay
+	// Closed by endPlay.
+	done chan struct{}
 }
 
 type Client struct {
 	server     *Server
 	conn       *websocket.Conn
-	sendCh     chan any
+	queue      *sendQueue
 	sessionID  string
 	clientName string
+	// Behind a trusted proxy, the address it forwarded for the client.
+	addr string
+
+	options ClientOptions
+	latency *latency
+	// Handled by voiceUpdatePump, in arrival order.
+	voiceUpdates chan json.RawMessage
+	// Stats ticks left until the client gets stats again, only touched by the
+	// stats ticker.
+	statsSkip int
 
 	players   map[snowflake.ID]*Player
+	detached  map[snowflake.ID]*detachedPlayer
 	playersMu sync.RWMutex
 
 	closeChan chan struct{}
 	closeOnce sync.Once
 }
 
-func NewClient(server *Server, conn *websocket.Conn, clientName string) *Client {
+// ClientOptions are chosen by the client with query params when connecting.
+type ClientOptions struct {
+	Stats bool
+	// StateUpdates sends a player update on every state change, not only on
+	// explicit actions, so clients don't have to poll to stay in sync.
+	StateUpdates bool
+	Heartbeat    Heartbeat
+	Defaults     PlayerDefaults
+}
+
+func NewClient(server *Server, conn *websocket.Conn, clientName, addr string, options ClientOptions) *Client {
 	return &Client{
-		server:     server,
-		conn:       conn,
-		sendCh:     make(chan any, 256),
-		sessionID:  uuid.New().String(),
-		clientName: clientName,
-		players:    make(map[snowflake.ID]*Player),
-		closeChan:  make(chan struct{}),
+		server:       server,
+		conn:         conn,
+		queue:        newSendQueue(),
+		voiceUpdates: make(chan json.RawMessage, VOICE_UPDATE_QUEUE_SIZE),
+		sessionID:    uuid.New().String(),
+		clientName:   clientName,
+		addr:         addr,
+		options:      options,
+		latency:      newLatency(),
+		players:      make(map[snowflake.ID]*Player),
+		detached:     make(map[snowflake.ID]*detachedPlayer),
+		closeChan:    make(chan struct{}),
 	}
 }
 
@@ -64,13 +133,14 @@ func (c *Client) readPump() {
 	}()
 
 	c.conn.SetReadLimit(MAX_MESSAGE_SIZE)
-	c.conn.SetReadDeadline(time.Now().Add(PONG_TIMEOUT))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(PONG_TIMEOUT))
+	c.conn.SetReadDeadline(time.Now().Add(c.options.Heartbeat.initialTimeout()))
+	c.conn.SetPongHandler(func(payload string) error {
+		c.latency.observe(payload, c.options.Heartbeat.PongTimeout)
+		c.conn.SetReadDeadline(time.Now().Add(c.options.Heartbeat.PongTimeout))
 		return nil
 	})
 
-	for {
+	for first := true; ; first = false {
 		msgType, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
@@ -78,66 +148,206 @@ func (c *Client) readPump() {
 			}
 			return
 		}
+		// The client is reading by now, the initial grace is over.
+		if first {
+			c.conn.SetReadDeadline(time.Now().Add(c.options.Heartbeat.PongTimeout))
+		}
 		c.server.handleMessage(c, msgType, message)
 	}
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(PING_PERIOD)
+	ticker := time.NewTicker(c.options.Heartbeat.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.close()
+		c.conn.Close()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.sendCh:
-			c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			data, err := json.Marshal(message)
-			if err != nil {
-				c.server.logger.Error("failed to marshal message", slog.Any("error", err))
-				continue
-			}
-
-			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				c.server.logger.Error("failed to write message", slog.Any("error", err))
-				return
+		case <-c.queue.ready:
+			for {
+				message, ok := c.queue.pop()
+				if !ok {
+					break
+				}
+				if err := c.writeMessage(message, time.Time{}); err != nil {
+					c.server.logger.Error("failed to write message", slog.Any("error", err))
+					return
+				}
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			c.conn.SetWriteDeadline(time.Now().Add(c.options.Heartbeat.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, c.latency.pingPayload()); err != nil {
 				return
 			}
 
 		case <-c.closeChan:
+			c.drain()
+			return
+		}
+	}
+}
+
+// writeMessage scales the deadline with the message size, unless it is given
+// one like the drain's overall deadline.
+func (c *Client) writeMessage(message any, deadline time.Time) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		c.server.logger.Error("failed to marshal message", slog.Any("error", err))
+		return nil
+	}
+
+	if deadline.IsZero() {
+		deadline = time.Now().Add(c.options.Heartbeat.writeTimeout(len(data)))
+	}
+	c.conn.SetWriteDeadline(deadline)
+
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// drain flushes messages that were queued before the close, like a final
+// trackEnd, and then says goodbye with a proper close frame.
+func (c *Client) drain() {
+	deadline := time.Now().Add(CLOSE_DRAIN_TIMEOUT)
+
+	for {
+		message, ok := c.queue.pop()
+		if !ok {
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+			return
+		}
+		if err := c.writeMessage(message, deadline); err != nil {
 			return
 		}
 	}
 }
 
 func (c *Client) send(msg protocol.Message) {
-	select {
-	case c.sendCh <- msg:
-	default:
-		c.server.logger.Warn("client send buffer full, dropping message")
+	class, policy := classEvent, c.server.sendPolicy.Events
+	if isStateMessage(msg.Op) {
+		class, policy = classState, c.server.sendPolicy.State
+	} else if isCriticalMessage(msg.Op) {
+		policy = CRITICAL_SEND_POLICY
+	}
+
+	if !c.queue.push(msg, class, policy, c.closeChan) {
+		c.server.logger.Warn("client send buffer full, dropping message",
+			slog.String("session", c.sessionID),
+			slog.Int("op", int(msg.Op)),
+		)
+	}
+}
+
+// stats queries the voice manager after releasing playersMu, like
+// destroyAllPlayers, each query takes the manager and connection locks.
+func (c *Client) stats() protocol.ClientStats {
+	c.playersMu.RLock()
+	guildIDs := slices.Collect(maps.Keys(c.players))
+	c.playersMu.RUnlock()
+
+	result := protocol.ClientStats{
+		SessionID: c.sessionID,
+		Name:      c.clientName,
+		Addr:      c.addr,
+		Latency:   c.latency.Milliseconds(),
+		Guilds:    make([]protocol.GuildStats, 0, len(guildIDs)),
+	}
+	for _, guildID := range guildIDs {
+		bandwidth := c.server.voiceManager.Bandwidth(c.sessionID, guildID)
+		result.Bandwidth = result.Bandwidth.Add(bandwidth)
+		result.Guilds = append(result.Guilds, protocol.GuildStats{
+			GuildID:    guildID,
+			Bandwidth:  bandwidth,
+			Healthy:    c.server.voiceManager.Healthy(c.sessionID, guildID),
+			Pacing:     c.server.voiceManager.PacingStats(c.sessionID, guildID),
+			Buffer:     c.server.voiceManager.Buffer(c.sessionID, guildID),
+			Reconnects: c.server.voiceManager.Reconnects(c.sessionID, guildID),
+		})
 	}
+	return result
+}
+
+func (c *Client) sendPlayerUpdate(guildID snowflake.ID, player *Player) {
+	queueSize, maxQueueSize := player.GetQueueSize()
+	data := protocol.PlayerUpdateData{
+		GuildID:      guildID,
+		State:        player.GetState(),
+		QueueSize:    queueSize,
+		MaxQueueSize: maxQueueSize,
+	}
+	pausedSince, pausedTotal := player.GetPauseInfo()
+	if !pausedSince.IsZero() {
+		data.PausedSince = pausedSince.UnixMilli()
+	}
+	data.PausedMs = pausedTotal.Milliseconds()
+	position, at := player.PositionAt()
+	data.Position = position
+	data.Timestamp = at.UnixMilli()
+	data.Latency = c.latency.Milliseconds()
+
+	c.send(protocol.Message{Op: protocol.OpPlayerUpdate, Data: data})
+}
+
+// sendStateChange reports changes that didn't come from a request of the
+// client, for clients that track player state purely from events.
+func (c *Client) sendStateChange(guildID snowflake.ID, player *Player) {
+	if c.options.StateUpdates {
+		c.sendPlayerUpdate(guildID, player)
+	}
+}
+
+func (c *Client) sendVoiceReady(guildID snowflake.ID) {
+	c.send(protocol.Message{
+		Op:   protocol.OpVoiceReady,
+		Data: protocol.VoiceReadyData{GuildID: guildID},
+	})
+}
+
+func (c *Client) sendError(op uint8, err error) {
+	data := protocol.ErrorData{Op: op, Error: err.Error()}
+	var fieldErr *protocol.FieldError
+	if errors.As(err, &fieldErr) {
+		data.Field = fieldErr.Field
+	}
+	c.send(protocol.Message{Op: protocol.OpError, Data: data})
+}
+
+func (c *Client) sendQueueUpdate(guildID snowflake.ID, player *Player) {
+	_, maxQueueSize := player.GetQueueSize()
+	c.send(protocol.Message{
+		Op: protocol.OpQueueUpdate,
+		Data: protocol.QueueUpdateData{
+			GuildID: guildID,
+			Queue:   player.GetQueue(),
+			MaxSize: maxQueueSize,
+		},
+	})
 }
 
 func (c *Client) close() {
 	c.closeOnce.Do(func() {
 		close(c.closeChan)
-		c.conn.Close()
 		c.server.unregisterClient(c)
 		c.server.logger.Info("client disconnected", slog.String("session", c.sessionID))
 	})
 }
 
+// context ends when the client disconnects.
+func (c *Client) context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-c.closeChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
 func (c *Client) getOrCreatePlayer(guildID snowflake.ID) *Player {
 	c.playersMu.Lock()
 	defer c.playersMu.Unlock()
@@ -147,8 +357,11 @@ func (c *Client) getOrCreatePlayer(guildID snowflake.ID) *Player {
 	}
 
 	player := &Player{
-		guildID: guildID,
-		state:   protocol.PlayerStateIdle,
+		guildID:  guildID,
+		state:    protocol.PlayerStateIdle,
+		volume:   c.options.Defaults.Volume,
+		maxQueue: c.server.maxQueue,
+		clock:    c.server.clock,
 	}
 	c.players[guildID] = player
 	return player
@@ -160,24 +373,104 @@ func (c *Client) getPlayer(guildID snowflake.ID) *Player {
 	return c.players[guildID]
 }
 
+// hasPlayer also counts a detached player, a voice update brings it back.
+func (c *Client) hasPlayer(guildID snowflake.ID) bool {
+	c.playersMu.RLock()
+	defer c.playersMu.RUnlock()
+
+	_, ok := c.players[guildID]
+	_, detached := c.detached[guildID]
+	return ok || detached
+}
+
+// removePlayer also drops a detached player, which the next voice update
+// would otherwise bring back.
 func (c *Client) removePlayer(guildID snowflake.ID) {
 	c.playersMu.Lock()
 	defer c.playersMu.Unlock()
 	delete(c.players, guildID)
+
+	if detached, ok := c.detached[guildID]; ok {
+		detached.timer.Stop()
+		delete(c.detached, guildID)
+	}
 }
 
-func (c *Client) destroyAllPlayers() {
+// destroyAllPlayers returns the guilds whose voice connections it closed.
+func (c *Client) destroyAllPlayers() []snowflake.ID {
 	c.playersMu.Lock()
 	guildIDs := make([]snowflake.ID, 0, len(c.players))
-	for id := range c.players {
+	for id, player := range c.players {
+		player.CancelPlay()
 		guildIDs = append(guildIDs, id)
 	}
 	c.players = make(map[snowflake.ID]*Player)
+	for _, detached := range c.detached {
+		detached.timer.Stop()
+	}
+	c.detached = make(map[snowflake.ID]*detachedPlayer)
 	c.playersMu.Unlock()
 
 	for _, guildID := range guildIDs {
 		c.server.voiceManager.Disconnect(c.sessionID, guildID)
 	}
+	return guildIDs
+}
+
+// beginPlay aborts the fetch of an earlier play that is still loading, as
+// the new one replaces it anyway. It then waits for that play to return, so a
+// client firing plays at a guild never has more than one source set up at a
+// time. The wait is short, a canceled play gives up at its next network call.
+// The context must outlive the play, the source keeps streaming with it, so
+// setup only bounds the wait and the fetch, like a batch's deadline does.
+func (p *Player) beginPlay(setup context.Context) (context.Context, *pendingPlay) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pending := &pendingPlay{cancel: cancel, stopSetup: context.AfterFunc(setup, cancel), done: make(chan struct{})}
+
+	p.mutex.Lock()
+	if p.pendingPlay != nil {
+		p.pendingPlay.cancel()
+	}
+	previous := p.settingUp
+	p.pendingPlay = pending
+	p.settingUp = pending
+	p.mutex.Unlock()
+
+	if previous != nil {
+		select {
+		case <-previous.done:
+		case <-ctx.Done():
+			// Plays after this one still wait for the one before.
+			pending.waitsFor = previous
+		}
+	}
+	return ctx, pending
+}
+
+// endPlay stops a play from being canceled once its source was set up.
+func (p *Player) endPlay(pending *pendingPlay) {
+	p.mutex.Lock()
+	if p.pendingPlay == pending {
+		p.pendingPlay = nil
+	}
+	if p.settingUp == pending {
+		// Nil unless this play gave up waiting on one still setting up.
+		p.settingUp = pending.waitsFor
+	}
+	p.mutex.Unlock()
+
+	pending.stopSetup()
+	close(pending.done)
+}
+
+// CancelPlay aborts a play that is still loading, for stops and disconnects.
+func (p *Player) CancelPlay() {
+	p.mutex.Lock()
+	if p.pendingPlay != nil {
+		p.pendingPlay.cancel()
+		p.pendingPlay = nil
+	}
+	p.mutex.Unlock()
 }
 
 func (p *Player) GetState() string {
@@ -195,36 +488,53 @@ func (p *Player) SetState(state string) {
 func (p *Player) GetCurrentURL() string {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
-	return p.currentURL
+	return p.current.URL
 }
 
 func (p *Player) SetCurrentURL(url string) {
 	p.mutex.Lock()
-	p.currentURL = url
+	p.current.URL = url
 	p.mutex.Unlock()
 }
 
-func (p *Player) GetPosition() int64 {
+// Position is the only place the position is derived from its anchor: the
+// anchored position, plus the time since it was anchored while playing scaled
+// by the speed of the active filters.
+func (p *Player) Position() int64 {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
-	return p.position
+	return p.currentPosition()
 }
 
-func (p *Player) SetPosition(pos int64) {
-	p.mutex.Lock()
-	p.position = pos
-	p.mutex.Unlock()
-}
-
-func (p *Player) GetStartedAt() time.Time {
+// PositionAt returns the position together with the instant it was derived
+// for, so clients can interpolate from exactly that point.
+func (p *Player) PositionAt() (int64, time.Time) {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
-	return p.startedAt
+	now := p.clock.Now()
+	return p.positionAt(now), now
 }
 
-func (p *Player) SetStartedAt(t time.Time) {
+// currentPosition must be called with the mutex held.
+func (p *Player) currentPosition() int64 {
+	return p.positionAt(p.clock.Now())
+}
+
+// positionAt must be called with the mutex held.
+func (p *Player) positionAt(now time.Time) int64 {
+	if p.state != protocol.PlayerStatePlaying {
+		return p.position
+	}
+	elapsed := float64(now.Sub(p.startedAt).Milliseconds()) * p.filters.PlaybackSpeed()
+	return p.position + int64(elapsed)
+}
+
+// SetPosition anchors the position, usually at the source's position after a
+// seek. While playing it advances with the player's clock from here on.
+func (p *Player) SetPosition(pos int64) {
 	p.mutex.Lock()
-	p.startedAt = t
+	p.position = pos
+	p.startedAt = p.clock.Now()
 	p.mutex.Unlock()
 }
 
@@ -240,50 +550,271 @@ func (p *Player) SetChannelID(id snowflake.ID) {
 	p.mutex.Unlock()
 }
 
-func (p *Player) SetPlayingState(url string, position int64, requesterID string, filters *filter.Filters) {
+func (p *Player) SetPlayingState(item protocol.QueueItem) {
 	p.mutex.Lock()
 	p.state = protocol.PlayerStatePlaying
-	p.currentURL = url
-	p.position = position
-	p.startedAt = time.Now()
-	p.requesterID = requesterID
-	p.filters = filters.Normalize()
+	p.current = item
+	p.position = item.StartTime
+	p.startedAt = p.clock.Now()
+	p.pausedAt = time.Time{}
+	p.pausedTotal = 0
+	p.filters = item.Filters.Normalize()
+	p.nextFilters = nil
 	p.mutex.Unlock()
 }
 
+// GetTrackInfo describes src with the metadata of the item it was started from.
+func (p *Player) GetTrackInfo(src source.Source) protocol.TrackInfo {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return protocol.TrackInfo{
+		URL:         src.URL(),
+		Title:       p.current.Title,
+		Duration:    src.Duration(),
+		RequesterID: p.current.RequesterID,
+	}
+}
+
 func (p *Player) GetRequesterID() string {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
-	return p.requesterID
+	return p.current.RequesterID
 }
 
 func (p *Player) SetRequesterID(id string) {
 	p.mutex.Lock()
-	p.requesterID = id
+	p.current.RequesterID = id
+	p.mutex.Unlock()
+}
+
+func (p *Player) SetFilters(filters *filter.Filters) {
+	p.mutex.Lock()
+	// Re-anchored so the time played so far keeps the speed it played at.
+	now := p.clock.Now()
+	p.position = p.positionAt(now)
+	p.startedAt = now
+	p.filters = filters.Normalize()
+	p.mutex.Unlock()
+}
+
+func (p *Player) SetNextFilters(filters *filter.Filters) {
+	p.mutex.Lock()
+	p.nextFilters = filters.Normalize()
+	p.mutex.Unlock()
+}
+
+func (p *Player) GetNextFilters() *filter.Filters {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.nextFilters
+}
+
+func (p *Player) GetVolume() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.volume
+}
+
+func (p *Player) SetVolume(volume int) {
+	p.mutex.Lock()
+	p.volume = volume
+	p.mutex.Unlock()
+}
+
+// GetBufferMs is nil while the player uses the node default.
+func (p *Player) GetBufferMs() *int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.bufferMs
+}
+
+func (p *Player) SetBufferMs(bufferMs int) {
+	p.mutex.Lock()
+	p.bufferMs = &bufferMs
 	p.mutex.Unlock()
 }
 
 func (p *Player) SetIdleState() {
 	p.mutex.Lock()
 	p.state = protocol.PlayerStateIdle
-	p.currentURL = ""
-	p.requesterID = ""
+	p.current = protocol.QueueItem{}
+	p.pausedAt = time.Time{}
+	p.pausedTotal = 0
 	p.mutex.Unlock()
 }
 
 func (p *Player) SetPausedState(position int64) {
 	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	p.state = protocol.PlayerStatePaused
 	p.position = position
+	if p.pausedAt.IsZero() {
+		p.pausedAt = p.clock.Now()
+	}
+}
+
+// SetResumedState re-anchors the position at the source's position in the
+// same step as the clock, so no reader sees the new anchor with the position
+// from before the pause.
+func (p *Player) SetResumedState(position int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.state = protocol.PlayerStatePlaying
+	p.position = position
+	p.startedAt = p.clock.Now()
+	if !p.pausedAt.IsZero() {
+		p.pausedTotal += clock.Since(p.clock, p.pausedAt)
+		p.pausedAt = time.Time{}
+	}
+}
+
+// GetPauseInfo reports since when the player is paused, zero when it isn't,
+// and how long the current track was paused in total.
+func (p *Player) GetPauseInfo() (pausedSince time.Time, pausedTotal time.Duration) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	pausedTotal = p.pausedTotal
+	if !p.pausedAt.IsZero() {
+		pausedTotal += clock.Since(p.clock, p.pausedAt)
+	}
+	return p.pausedAt, pausedTotal
+}
+
+func (p *Player) GetQueue() []protocol.QueueItem {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	// Never nil, so an empty queue is sent as [] rather than null.
+	return append([]protocol.QueueItem{}, p.queue...)
+}
+
+// AddToQueue adds all items or none, so a batch is never cut off halfway.
+func (p *Player) AddToQueue(items ...protocol.QueueItem) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.addToQueue(items...)
+}
+
+// EnqueueIfActive queues the item when a track is playing, paused or still
+// loading, and reports its 0-based queue position. Checked under the same lock
+// as the append, so a track that just ended can't leave the item stranded.
+func (p *Player) EnqueueIfActive(item protocol.QueueItem) (int, bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.state == protocol.PlayerStateIdle && p.pendingPlay == nil {
+		return 0, false, nil
+	}
+	if err := p.addToQueue(item); err != nil {
+		return 0, false, err
+	}
+	return len(p.queue) - 1, true, nil
+}
+
+// addToQueue must be called with the mutex held.
+func (p *Player) addToQueue(items ...protocol.QueueItem) error {
+	if p.maxQueue > 0 && len(p.queue)+len(items) > p.maxQueue {
+		return fmt.Errorf("%w: %d of %d tracks queued, can't add %d", ErrQueueFull, len(p.queue), p.maxQueue, len(items))
+	}
+	p.queue = append(p.queue, items...)
+	return nil
+}
+
+// PushFrontQueue ignores the limit, it puts back a track that was queued
+// before and would otherwise be lost.
+func (p *Player) PushFrontQueue(item protocol.QueueItem) {
+	p.mutex.Lock()
+	p.queue = slices.Insert(p.queue, 0, item)
+	p.mutex.Unlock()
+}
+
+func (p *Player) GetQueueSize() (size, limit int) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return len(p.queue), p.maxQueue
+}
+
+func (p *Player) PopQueue() (protocol.QueueItem, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.queue) == 0 {
+		return protocol.QueueItem{}, false
+	}
+
+	item := p.queue[0]
+	p.queue = slices.Delete(p.queue, 0, 1)
+	return item, true
+}
+
+func (p *Player) ClearQueue() {
+	p.mutex.Lock()
+	p.queue = nil
 	p.mutex.Unlock()
 }
 
-func (p *Player) GetMigrateData() (url string, position int64, state string, requesterID string, filters *filter.Filters) {
+// The playing track is not part of the queue, so every index refers to a
+// pending item and can be removed or moved freely.
+func (p *Player) RemoveFromQueue(index int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if index < 0 || index >= len(p.queue) {
+		return fmt.Errorf("queue index out of range: %d", index)
+	}
+
+	p.queue = slices.Delete(p.queue, index, index+1)
+	return nil
+}
+
+func (p *Player) MoveInQueue(from, to int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if from < 0 || from >= len(p.queue) {
+		return fmt.Errorf("queue index out of range: %d", from)
+	}
+	if to < 0 || to >= len(p.queue) {
+		return fmt.Errorf("queue index out of range: %d", to)
+	}
+
+	item := p.queue[from]
+	p.queue = slices.Insert(slices.Delete(p.queue, from, from+1), to, item)
+	return nil
+}
+
+// ShuffleQueue only reorders pending items, the current track lives outside the queue.
+func (p *Player) ShuffleQueue() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	rand.Shuffle(len(p.queue), func(i, j int) {
+		p.queue[i], p.queue[j] = p.queue[j], p.queue[i]
+	})
+}
+
+// GetCurrentItem captures the playing track as a queue item that resumes at
+// the given position, so it can be put back into the queue.
+func (p *Player) GetCurrentItem(position int64) (protocol.QueueItem, bool) {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
-	calculatedPos := p.position
-	if p.state == protocol.PlayerStatePlaying {
-		calculatedPos = time.Since(p.startedAt).Milliseconds() + p.position
+
+	if p.state == protocol.PlayerStateIdle || p.current.URL == "" {
+		return protocol.QueueItem{}, false
 	}
-	return p.currentURL, calculatedPos, p.state, p.requesterID, p.filters
+
+	item := p.current
+	item.StartTime = position
+	// Changed by filter updates since the track started.
+	item.Filters = p.filters
+	return item, true
+}
+
+func (p *Player) GetMigrateData() (url string, position int64, state string, requesterID string, filters *filter.Filters, volume int) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.current.URL, p.currentPosition(), p.state, p.current.RequesterID, p.filters, p.volume
 }
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/audio/filter"
+	"github.com/shi-gg/linkdave/server/audio/source"
+	"github.com/shi-gg/linkdave/server/clock"
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+func newTestPlayer() *Player {
+	return &Player{state: protocol.PlayerStateIdle, clock: clock.SYSTEM}
+}
+
+func TestGetCurrentItemKeepsTrackSettings(t *testing.T) {
+	normalize := true
+	bitrate := 64000
+	item := protocol.QueueItem{
+		URL:         "https://example.com/tts.mp3",
+		StartTime:   1500,
+		Title:       "announcement",
+		RequesterID: "42",
+		Normalize:   &normalize,
+		Bitrate:     &bitrate,
+		Signal:      source.SignalVoice,
+	}
+
+	player := newTestPlayer()
+	player.SetPlayingState(item)
+
+	got, ok := player.GetCurrentItem(9000)
+	if !ok {
+		t.Fatal("expected a current item")
+	}
+
+	want := item
+	want.StartTime = 9000
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("current item = %+v, want %+v", got, want)
+	}
+}
+
+// Reload replays GetCurrentItem, from 0 when asked to start over.
+func TestGetCurrentItemForReload(t *testing.T) {
+	normalize := false
+	bitrate := 96000
+	item := protocol.QueueItem{
+		URL:       "https://example.com/a.mp3",
+		StartTime: 30000,
+		Normalize: &normalize,
+		Bitrate:   &bitrate,
+		Signal:    source.SignalMusic,
+	}
+
+	player := newTestPlayer()
+	player.SetPlayingState(item)
+	filters := &filter.Filters{Speed: 1.25}
+	player.SetFilters(filters)
+
+	got, ok := player.GetCurrentItem(0)
+	if !ok {
+		t.Fatal("expected a current item")
+	}
+
+	want := item
+	want.StartTime = 0
+	want.Filters = filters.Normalize()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("reload item = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetCurrentItemIdle(t *testing.T) {
+	player := newTestPlayer()
+	player.SetPlayingState(protocol.QueueItem{URL: "https://example.com/a.mp3"})
+	player.SetIdleState()
+
+	if _, ok := player.GetCurrentItem(0); ok {
+		t.Fatal("an idle player has nothing to reload")
+	}
+}
+
+func TestRemovePlayerDropsDetachedPlayer(t *testing.T) {
+	guildID := snowflake.ID(1)
+	client := &Client{
+		players:  map[snowflake.ID]*Player{guildID: newTestPlayer()},
+		detached: make(map[snowflake.ID]*detachedPlayer),
+	}
+
+	client.detachPlayer(guildID, time.Minute)
+	client.removePlayer(guildID)
+
+	if _, ok := client.reattachPlayer(guildID); ok {
+		t.Fatal("a removed player must not come back on the next voice update")
+	}
+}
+
+func newClockedPlayer() (*Player, *clock.Manual) {
+	manual := clock.NewManual(time.Unix(1_700_000_000, 0))
+	return &Player{state: protocol.PlayerStateIdle, clock: manual}, manual
+}
+
+func TestPositionScalesWithSpeed(t *testing.T) {
+	player, manual := newClockedPlayer()
+	player.SetPlayingState(protocol.QueueItem{
+		URL:       "https://example.com/song.mp3",
+		StartTime: 1000,
+		Filters:   &filter.Filters{Speed: 1.5},
+	})
+
+	manual.Advance(2 * time.Second)
+	if got := player.Position(); got != 4000 {
+		t.Fatalf("position = %d, want 4000", got)
+	}
+}
+
+func TestPositionKeepsSpeedPlayedAt(t *testing.T) {
+	player, manual := newClockedPlayer()
+	player.SetPlayingState(protocol.QueueItem{URL: "https://example.com/song.mp3"})
+
+	manual.Advance(time.Second)
+	player.SetFilters(&filter.Filters{Enabled: []filter.Type{filter.Nightcore}})
+	manual.Advance(time.Second)
+
+	// 1s at normal speed, then 1s at nightcore's 1.3x.
+	if got := player.Position(); got != 2300 {
+		t.Fatalf("position = %d, want 2300", got)
+	}
+
+	_, position, _, _, _, _ := player.GetMigrateData()
+	if position != 2300 {
+		t.Fatalf("migrate position = %d, want 2300", position)
+	}
+}
+
+func TestStopCancelsLoadingPlay(t *testing.T) {
+	player := newTestPlayer()
+
+	ctx, pending := player.beginPlay(context.Background())
+	player.CancelPlay()
+	if ctx.Err() == nil {
+		t.Fatal("play still loading after a stop")
+	}
+	player.endPlay(pending)
+
+	// A stop only discards the plays before it.
+	ctx, pending = player.beginPlay(context.Background())
+	defer player.endPlay(pending)
+	if ctx.Err() != nil {
+		t.Fatal("play after a stop was canceled")
+	}
+}
+
+func TestLaterPlayCancelsLoadingPlay(t *testing.T) {
+	player := newTestPlayer()
+
+	first, firstPending := player.beginPlay(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-first.Done()
+		player.endPlay(firstPending)
+	}()
+
+	second, secondPending := player.beginPlay(context.Background())
+	defer player.endPlay(secondPending)
+	<-done
+
+	if first.Err() == nil {
+		t.Fatal("earlier play was not canceled")
+	}
+	if second.Err() != nil {
+		t.Fatal("later play was canceled")
+	}
+}
+
+func TestConcurrentPlaysSetUpOneAtATime(t *testing.T) {
+	const plays = 5
+	player := newTestPlayer()
+
+	var inFlight, maxInFlight atomic.Int32
+	proceeded := make([]bool, plays)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := range plays {
+		player.mutex.RLock()
+		previous := player.pendingPlay
+		player.mutex.RUnlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Mirrors playItem, the source setup waits for release or a cancel.
+			ctx, pending := player.beginPlay(context.Background())
+			defer player.endPlay(pending)
+			if ctx.Err() != nil {
+				return
+			}
+
+			n := inFlight.Add(1)
+			for {
+				highest := maxInFlight.Load()
+				if n <= highest || maxInFlight.CompareAndSwap(highest, n) {
+					break
+				}
+			}
+			select {
+			case <-ctx.Done():
+			case <-release:
+			}
+			inFlight.Add(-1)
+
+			proceeded[i] = ctx.Err() == nil
+		}()
+
+		// Starts the plays in a known order, the last one is the one to keep.
+		for {
+			player.mutex.RLock()
+			registered := player.pendingPlay != previous
+			player.mutex.RUnlock()
+			if registered {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	close(release)
+	wg.Wait()
+
+	if got := maxInFlight.Load(); got > 1 {
+		t.Fatalf("%d plays set up at once", got)
+	}
+	for i, ok := range proceeded {
+		if ok != (i == plays-1) {
+			t.Fatalf("play %d proceeded = %v, want only the last one to", i, ok)
+		}
+	}
+}
+
+func TestSetupDeadlineEndsWaitingPlay(t *testing.T) {
+	player := newTestPlayer()
+
+	_, firstPending := player.beginPlay(context.Background())
+
+	setup, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	second, secondPending := player.beginPlay(setup)
+	if second.Err() == nil {
+		t.Fatal("play kept waiting past its setup deadline")
+	}
+	player.endPlay(secondPending)
+
+	// The one after still waits for the first play to return.
+	third := make(chan struct{})
+	go func() {
+		defer close(third)
+		_, pending := player.beginPlay(context.Background())
+		player.endPlay(pending)
+	}()
+	select {
+	case <-third:
+		t.Fatal("play set up while an earlier one still was")
+	case <-time.After(20 * time.Millisecond):
+	}
+	player.endPlay(firstPending)
+	<-third
+}
+
+func TestSetupDeadlineSparesStartedPlay(t *testing.T) {
+	player := newTestPlayer()
+
+	setup, cancel := context.WithCancel(context.Background())
+	ctx, pending := player.beginPlay(setup)
+	player.endPlay(pending)
+	cancel()
+
+	if ctx.Err() != nil {
+		t.Fatal("play stopped streaming when its request ended")
+	}
+}
+
+func TestDetachCancelsLoadingPlay(t *testing.T) {
+	guildID := snowflake.ID(1)
+	player := newTestPlayer()
+	client := &Client{
+		players:  map[snowflake.ID]*Player{guildID: player},
+		detached: make(map[snowflake.ID]*detachedPlayer),
+	}
+
+	ctx, pending := player.beginPlay(context.Background())
+	defer player.endPlay(pending)
+	client.detachPlayer(guildID, time.Minute)
+	defer client.removePlayer(guildID)
+
+	if ctx.Err() == nil {
+		t.Fatal("play kept loading after the session went away")
+	}
+}
+
+func TestPauseInfoAddsUpPauses(t *testing.T) {
+	player, manual := newClockedPlayer()
+	player.SetPlayingState(protocol.QueueItem{URL: "https://example.com/song.mp3"})
+
+	manual.Advance(time.Second)
+	player.SetPausedState(1000)
+	pausedAt := manual.Now()
+	manual.Advance(3 * time.Second)
+
+	since, total := player.GetPauseInfo()
+	if !since.Equal(pausedAt) || total != 3*time.Second {
+		t.Fatalf("while paused = (%v, %v), want (%v, 3s)", since, total, pausedAt)
+	}
+
+	player.SetResumedState(1000)
+	manual.Advance(time.Second)
+	player.SetPausedState(2000)
+	manual.Advance(2 * time.Second)
+	player.SetResumedState(2000)
+
+	since, total = player.GetPauseInfo()
+	if !since.IsZero() || total != 5*time.Second {
+		t.Fatalf("after resuming = (%v, %v), want (zero, 5s)", since, total)
+	}
+
+	player.SetPlayingState(protocol.QueueItem{URL: "https://example.com/next.mp3"})
+	if _, total := player.GetPauseInfo(); total != 0 {
+		t.Fatalf("next track starts with %v paused", total)
+	}
+}
+
+func TestPositionFollowsPlaybackState(t *testing.T) {
+	player, manual := newClockedPlayer()
+	player.SetPlayingState(protocol.QueueItem{URL: "https://example.com/song.mp3", StartTime: 500})
+
+	manual.Advance(time.Second)
+	position, at := player.PositionAt()
+	if position != 1500 || !at.Equal(manual.Now()) {
+		t.Fatalf("PositionAt = (%d, %v), want (1500, %v)", position, at, manual.Now())
+	}
+
+	player.SetPausedState(1500)
+	manual.Advance(time.Minute)
+	if got := player.Position(); got != 1500 {
+		t.Fatalf("paused position = %d, want 1500", got)
+	}
+
+	player.SetResumedState(1500)
+	manual.Advance(time.Second)
+	player.SetPosition(10_000)
+	manual.Advance(time.Second)
+	if got := player.Position(); got != 11_000 {
+		t.Fatalf("position after a seek = %d, want 11000", got)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/shi-gg/linkdave/server/audio/filter"
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+// PlayerDefaults are applied to every player a client creates, so bots don't
+// have to send the same volume and filters after each voice connect.
+type PlayerDefaults struct {
+	Volume int
+	// Filters are used for tracks that are played without their own filters.
+	Filters *filter.Filters
+	// PlayMode is used for plays that don't set their own mode.
+	PlayMode string
+}
+
+var DEFAULT_PLAYER_DEFAULTS = PlayerDefaults{Volume: filter.DEFAULT_VOLUME, PlayMode: protocol.PlayModeReplace}
+
+func playerDefaultsFromQuery(query url.Values) (PlayerDefaults, error) {
+	defaults := DEFAULT_PLAYER_DEFAULTS
+
+	if value := query.Get("volume"); value != "" {
+		volume, err := strconv.Atoi(value)
+		if err != nil {
+			return defaults, fmt.Errorf("invalid volume: %w", err)
+		}
+		if err := filter.ValidateVolume(volume); err != nil {
+			return defaults, err
+		}
+		defaults.Volume = volume
+	}
+
+	if value := query.Get("filters"); value != "" {
+		var filters filter.Filters
+		if err := json.Unmarshal([]byte(value), &filters); err != nil {
+			return defaults, fmt.Errorf("invalid filters: %w", err)
+		}
+		if err := filters.Validate(); err != nil {
+			return defaults, err
+		}
+		defaults.Filters = filters.Normalize()
+	}
+
+	if value := query.Get("play_mode"); value != "" {
+		if err := protocol.ValidatePlayMode(value); err != nil {
+			return defaults, err
+		}
+		defaults.PlayMode = value
+	}
+
+	return defaults, nil
+}
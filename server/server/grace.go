@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/protocol"
+	"github.com/shi-gg/linkdave/server/voice"
+)
+
+// detachedPlayer is a player whose voice connection dropped, kept for the
+// player grace period so a voice blip doesn't cost the queue and filters.
+type detachedPlayer struct {
+	player *Player
+	timer  *time.Timer
+	// The track that was playing, resumed where it stopped on reattach.
+	resume *protocol.QueueItem
+	paused bool
+}
+
+// SetPlayerGrace must be called before the server accepts connections, 0
+// removes players right when their voice connection drops.
+func (s *Server) SetPlayerGrace(grace time.Duration) {
+	s.playerGrace = grace
+}
+
+func (c *Client) detachPlayer(guildID snowflake.ID, grace time.Duration) {
+	c.playersMu.Lock()
+	defer c.playersMu.Unlock()
+
+	player, ok := c.players[guildID]
+	if !ok {
+		return
+	}
+	delete(c.players, guildID)
+	player.CancelPlay()
+
+	if grace <= 0 {
+		return
+	}
+
+	detached := &detachedPlayer{player: player, paused: player.GetState() == protocol.PlayerStatePaused}
+	if item, ok := player.GetCurrentItem(player.Position()); ok {
+		detached.resume = &item
+	}
+	player.SetIdleState()
+
+	detached.timer = time.AfterFunc(grace, func() {
+		c.playersMu.Lock()
+		defer c.playersMu.Unlock()
+
+		if c.detached[guildID] == detached {
+			delete(c.detached, guildID)
+		}
+	})
+	c.detached[guildID] = detached
+}
+
+// reattachPlayer brings back a detached player, unless the guild got a new
+// player in the meantime.
+func (c *Client) reattachPlayer(guildID snowflake.ID) (*detachedPlayer, bool) {
+	c.playersMu.Lock()
+	defer c.playersMu.Unlock()
+
+	detached, ok := c.detached[guildID]
+	if !ok {
+		return nil, false
+	}
+	delete(c.detached, guildID)
+	detached.timer.Stop()
+
+	if _, exists := c.players[guildID]; exists {
+		return nil, false
+	}
+	c.players[guildID] = detached.player
+	return detached, true
+}
+
+func (s *Server) resumePlayer(client *Client, guildID snowflake.ID, detached *detachedPlayer) {
+	player := detached.player
+	if detached.resume == nil {
+		client.sendStateChange(guildID, player)
+		return
+	}
+
+	_, err := s.playItem(context.Background(), client, guildID, player, *detached.resume, detached.paused)
+	if err == nil || errors.Is(err, voice.ErrPlaybackSuperseded) {
+		return
+	}
+
+	s.logger.Warn("failed to resume reattached player",
+		slog.String("guild_id", guildID.String()),
+		trackAttr(detached.resume.URL, detached.resume.Title, detached.resume.RequesterID),
+		slog.Any("error", err),
+	)
+	client.sendStateChange(guildID, player)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_WRITE_TIMEOUT   = 10 * time.Second
+	DEFAULT_PONG_TIMEOUT    = 60 * time.Second
+	DEFAULT_INITIAL_TIMEOUT = 120 * time.Second
+
+	// Bytes per second, a client reading slower than this is treated as gone
+	// once the base write timeout is used up as well.
+	DEFAULT_WRITE_RATE = 64 * 1024
+)
+
+var DEFAULT_HEARTBEAT = Heartbeat{
+	WriteTimeout:   DEFAULT_WRITE_TIMEOUT,
+	PongTimeout:    DEFAULT_PONG_TIMEOUT,
+	PingPeriod:     pingPeriodFor(DEFAULT_PONG_TIMEOUT),
+	InitialTimeout: DEFAULT_INITIAL_TIMEOUT,
+	WriteRate:      DEFAULT_WRITE_RATE,
+}
+
+type Heartbeat struct {
+	WriteTimeout time.Duration
+	PongTimeout  time.Duration
+	PingPeriod   time.Duration
+	// Applies until the client's first message or pong, as clients on a slow
+	// cold start may not read in time to answer the first ping.
+	InitialTimeout time.Duration
+	// WriteRate extends the write timeout by the time a message of its size
+	// takes at this many bytes per second, 0 keeps the timeout flat.
+	WriteRate int
+}
+
+// initialTimeout is never stricter than the pong timeout.
+func (h Heartbeat) initialTimeout() time.Duration {
+	return max(h.InitialTimeout, h.PongTimeout)
+}
+
+// writeTimeout keeps small messages on the tight base timeout, while a large
+// snapshot or migration dump to a slow client isn't taken for a dead one.
+func (h Heartbeat) writeTimeout(size int) time.Duration {
+	if h.WriteRate <= 0 {
+		return h.WriteTimeout
+	}
+	return h.WriteTimeout + time.Duration(size)*time.Second/time.Duration(h.WriteRate)
+}
+
+// Leaves a tenth of the pong timeout for the ping to make the round trip.
+func pingPeriodFor(pongTimeout time.Duration) time.Duration {
+	return pongTimeout * 9 / 10
+}
+
+func (h Heartbeat) Validate() error {
+	if h.WriteTimeout <= 0 || h.PongTimeout <= 0 || h.PingPeriod <= 0 {
+		return errors.New("heartbeat durations must be positive")
+	}
+	if h.PingPeriod >= h.PongTimeout {
+		return fmt.Errorf("ping period (%s) must be shorter than pong timeout (%s)", h.PingPeriod, h.PongTimeout)
+	}
+	return nil
+}
+
+// WithPongTimeout derives the ping period unless one is given, so only the
+// timeout has to be tuned in the common case.
+func (h Heartbeat) WithPongTimeout(pongTimeout, pingPeriod time.Duration) Heartbeat {
+	if pongTimeout > 0 {
+		h.PongTimeout = pongTimeout
+		h.PingPeriod = pingPeriodFor(pongTimeout)
+	}
+	if pingPeriod > 0 {
+		h.PingPeriod = pingPeriod
+	}
+	return h
+}
+
+// withQuery applies the `pong_timeout` and `ping_period` (ms) query params of a
+// client, for links where the node wide defaults are too strict or too lax.
+func (h Heartbeat) withQuery(query url.Values) (Heartbeat, error) {
+	pongTimeout, err := parseMs(query.Get("pong_timeout"))
+	if err != nil {
+		return h, fmt.Errorf("invalid pong_timeout: %w", err)
+	}
+	initialTimeout, err := parseMs(query.Get("initial_timeout"))
+	if err != nil {
+		return h, fmt.Errorf("invalid initial_timeout: %w", err)
+	}
+	if initialTimeout > 0 {
+		h.InitialTimeout = initialTimeout
+	}
+	pingPeriod, err := parseMs(query.Get("ping_period"))
+	if err != nil {
+		return h, fmt.Errorf("invalid ping_period: %w", err)
+	}
+
+	h = h.WithPongTimeout(pongTimeout, pingPeriod)
+	return h, h.Validate()
+}
+
+func parseMs(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ms <= 0 {
+		return 0, errors.New("must be positive")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
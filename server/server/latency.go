@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// New samples count for an eighth, like TCP's smoothed RTT, so one slow
+	// pong doesn't swing the reported latency.
+	LATENCY_SMOOTHING = 8
+
+	STATS_INTERVAL = 5 * time.Second
+	// Every millisecond of round trip adds this much to a client's stats
+	// interval, a client on a slow link gets fewer stats to drain instead of
+	// its send queue filling up with ones that are outdated on arrival.
+	STATS_RTT_FACTOR   = 20
+	MAX_STATS_INTERVAL = 30 * time.Second
+)
+
+// latency measures the round trip to a client from its pongs, which echo the
+// ping's payload. Pings carry their send time relative to epoch, which keeps
+// the measurement on the monotonic clock.
+type latency struct {
+	epoch time.Time
+	// Smoothed round trip in nanoseconds, 0 until the first pong.
+	rtt atomic.Int64
+}
+
+func newLatency() *latency {
+	return &latency{epoch: time.Now()}
+}
+
+func (l *latency) pingPayload() []byte {
+	return strconv.AppendInt(nil, int64(time.Since(l.epoch)), 10)
+}
+
+// observe ignores pongs that don't echo a ping of ours or came back later
+// than maxRTT, which a client answering late would otherwise report.
+func (l *latency) observe(payload string, maxRTT time.Duration) {
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return
+	}
+	sample := int64(time.Since(l.epoch)) - sent
+	if sample < 0 || sample > int64(maxRTT) {
+		return
+	}
+
+	// Only the read pump observes, so the load and store can't race each other.
+	current := l.rtt.Load()
+	if current == 0 {
+		l.rtt.Store(sample)
+		return
+	}
+	l.rtt.Store(current + (sample-current)/LATENCY_SMOOTHING)
+}
+
+// statsTicks is how many STATS_INTERVAL ticks apart the client gets stats.
+func (l *latency) statsTicks() int {
+	interval := min(STATS_INTERVAL+time.Duration(l.rtt.Load())*STATS_RTT_FACTOR, MAX_STATS_INTERVAL)
+	return int(interval / STATS_INTERVAL)
+}
+
+// Milliseconds is 0 until the client answered a ping.
+func (l *latency) Milliseconds() int64 {
+	return time.Duration(l.rtt.Load()).Milliseconds()
+}
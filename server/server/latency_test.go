@@ -0,0 +1,44 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStatsTicksGrowWithLatency(t *testing.T) {
+	tests := []struct {
+		rtt  time.Duration
+		want int
+	}{
+		{0, 1},
+		{100 * time.Millisecond, 1},
+		{250 * time.Millisecond, 2},
+		{2 * time.Second, int(MAX_STATS_INTERVAL / STATS_INTERVAL)},
+	}
+
+	for _, tt := range tests {
+		l := newLatency()
+		l.rtt.Store(int64(tt.rtt))
+		if got := l.statsTicks(); got != tt.want {
+			t.Errorf("statsTicks at %v = %d, want %d", tt.rtt, got, tt.want)
+		}
+	}
+}
+
+func TestObserveSmoothsAndIgnoresLatePongs(t *testing.T) {
+	l := newLatency()
+	sent := int64(time.Since(l.epoch) - 80*time.Millisecond)
+	l.observe(strconv.FormatInt(sent, 10), time.Second)
+	first := l.rtt.Load()
+	if first < int64(80*time.Millisecond) {
+		t.Fatalf("first sample = %v, want at least 80ms", time.Duration(first))
+	}
+
+	late := int64(time.Since(l.epoch) - 2*time.Second)
+	l.observe(strconv.FormatInt(late, 10), time.Second)
+	l.observe("not a ping", time.Second)
+	if l.rtt.Load() != first {
+		t.Fatal("late or foreign pongs changed the latency")
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+const (
+	// ReadMemStats stops the world, so it runs on a timer rather than per request.
+	MEMORY_CHECK_INTERVAL = time.Second
+	// Shedding ends below this share of the limit, so a node hovering right at
+	// the limit doesn't flip between the two for every collection.
+	MEMORY_RECOVERY_RATIO = 0.9
+)
+
+var ErrNodeOverloaded = errors.New("node_overloaded")
+
+// SetMemoryLimit must be called before the server accepts connections, 0
+// never sheds. Over the limit, new players and plays are refused until the
+// heap shrinks, which beats the OOM killer ending every player at once.
+func (s *Server) SetMemoryLimit(limit uint64) {
+	s.memoryLimit = limit
+	if limit == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(MEMORY_CHECK_INTERVAL)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			var memStats runtime.MemStats
+			runtime.ReadMemStats(&memStats)
+			s.checkMemory(memStats.Alloc)
+		}
+	}()
+}
+
+func (s *Server) checkMemory(alloc uint64) {
+	overloaded := s.overloaded.Load()
+	switch {
+	case !overloaded && alloc >= s.memoryLimit:
+		s.overloaded.Store(true)
+		s.logger.Warn("memory limit reached, refusing new players",
+			slog.Uint64("alloc", alloc),
+			slog.Uint64("limit", s.memoryLimit),
+		)
+	case overloaded && float64(alloc) < float64(s.memoryLimit)*MEMORY_RECOVERY_RATIO:
+		s.overloaded.Store(false)
+		s.logger.Info("memory recovered, accepting new players", slog.Uint64("alloc", alloc))
+	}
+}
+
+func (s *Server) IsOverloaded() bool {
+	return s.overloaded.Load()
+}
+
+// playRequested is playItem for tracks a client asked to start, which are
+// refused over the memory limit. Queue advances and resumes of existing
+// players carry on.
+func (s *Server) playRequested(ctx context.Context, client *Client, guildID snowflake.ID, player *Player, item protocol.QueueItem, paused bool) (int64, error) {
+	return s.playRequestedWith(ctx, client, guildID, player, item, paused, player.GetVolume(), player.GetBufferMs())
+}
+
+func (s *Server) playRequestedWith(ctx context.Context, client *Client, guildID snowflake.ID, player *Player, item protocol.QueueItem, paused bool, volume int, bufferMs *int) (int64, error) {
+	if s.IsOverloaded() {
+		return 0, ErrNodeOverloaded
+	}
+	return s.playItemWith(ctx, client, guildID, player, item, paused, volume, bufferMs)
+}
+
+// refuseNewPlayer turns away a voice update that would add a player, updates
+// for guilds that have one, like voice server changes, still go through.
+func (s *Server) refuseNewPlayer(client *Client, guildID snowflake.ID) bool {
+	if !s.IsOverloaded() || client.hasPlayer(guildID) {
+		return false
+	}
+
+	s.logger.Warn("voice update refused, node is over its memory limit", slog.String("guild_id", guildID.String()))
+	client.send(protocol.Message{
+		Op: protocol.OpVoiceDisconnect,
+		Data: protocol.VoiceDisconnectData{
+			GuildID: guildID,
+			Reason:  protocol.DisconnectReasonNodeOverloaded,
+		},
+	})
+	return true
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// OriginMode decides which browser origins may open a WebSocket. Bots don't
+// send an Origin header, requests without one are always accepted.
+type OriginMode string
+
+const (
+	OriginAllowAll OriginMode = "allow-all"
+	// The origin's host must be the host the request was sent to.
+	OriginSameHost OriginMode = "same-host"
+	// The origin must be one of OriginPolicy.Allowlist.
+	OriginAllowlist OriginMode = "allowlist"
+)
+
+type OriginPolicy struct {
+	Mode OriginMode
+	// Full origins like https://dashboard.example.com, or bare hosts.
+	Allowlist []string
+}
+
+// Nodes used to accept every origin, so that stays the default.
+var DEFAULT_ORIGIN_POLICY = OriginPolicy{Mode: OriginAllowAll}
+
+func ParseOriginMode(value string) (OriginMode, error) {
+	switch mode := OriginMode(value); mode {
+	case OriginAllowAll, OriginSameHost, OriginAllowlist:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown origin mode %q", value)
+	}
+}
+
+func (p OriginPolicy) Validate() error {
+	if p.Mode == OriginAllowlist && len(p.Allowlist) == 0 {
+		return fmt.Errorf("the %s origin mode needs at least one allowed origin", OriginAllowlist)
+	}
+	return nil
+}
+
+// SetOriginPolicy must be called before the server accepts connections.
+func (s *Server) SetOriginPolicy(policy OriginPolicy) {
+	s.originPolicy = policy
+}
+
+func (s *Server) checkOrigin(r *http.Request) bool {
+	policy := s.originPolicy
+	origin := r.Header.Get("Origin")
+	if policy.Mode == OriginAllowAll || origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	switch policy.Mode {
+	case OriginSameHost:
+		return strings.EqualFold(u.Host, r.Host)
+	case OriginAllowlist:
+		return slices.ContainsFunc(policy.Allowlist, func(allowed string) bool {
+			return strings.EqualFold(allowed, origin) || strings.EqualFold(allowed, u.Host)
+		})
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+// OverflowMode decides what happens to a message when a slow client's send
+// queue is full.
+type OverflowMode string
+
+const (
+	OverflowDropNewest OverflowMode = "drop_newest"
+	// Keeps the latest snapshot, for messages that supersede older ones.
+	OverflowDropOldest OverflowMode = "drop_oldest"
+	// Waits up to OverflowPolicy.Wait for room before dropping the message.
+	OverflowBlock OverflowMode = "block"
+)
+
+const (
+	EVENT_QUEUE_SIZE = 256
+	STATE_QUEUE_SIZE = 64
+
+	// How long critical messages wait for room, whatever the event policy.
+	CRITICAL_SEND_WAIT = 5 * time.Second
+)
+
+var CRITICAL_SEND_POLICY = OverflowPolicy{Mode: OverflowBlock, Wait: CRITICAL_SEND_WAIT}
+
+type OverflowPolicy struct {
+	Mode OverflowMode
+	Wait time.Duration
+}
+
+func ParseOverflowMode(value string) (OverflowMode, error) {
+	switch mode := OverflowMode(value); mode {
+	case OverflowDropNewest, OverflowDropOldest, OverflowBlock:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown overflow mode %q", value)
+	}
+}
+
+// SendPolicy is split by message class: events like track ends should reach
+// the client, while state messages are snapshots that only matter when fresh.
+type SendPolicy struct {
+	Events OverflowPolicy
+	State  OverflowPolicy
+}
+
+// Events default to dropping instead of blocking, as some are sent from the
+// voice frame loop.
+var DEFAULT_SEND_POLICY = SendPolicy{
+	Events: OverflowPolicy{Mode: OverflowDropNewest},
+	State:  OverflowPolicy{Mode: OverflowDropOldest},
+}
+
+func isStateMessage(op uint8) bool {
+	switch op {
+	case protocol.OpPlayerUpdate, protocol.OpStats, protocol.OpVoiceHealth, protocol.OpQueueUpdate, protocol.OpBuffering:
+		return true
+	default:
+		return false
+	}
+}
+
+// isCriticalMessage is true for messages a client can't recover from missing,
+// without the drain notice it never migrates and is cut off at the deadline.
+func isCriticalMessage(op uint8) bool {
+	switch op {
+	case protocol.OpNodeDraining, protocol.OpMigrateReady:
+		return true
+	default:
+		return false
+	}
+}
+
+type messageClass int
+
+const (
+	classEvent messageClass = iota
+	classState
+)
+
+// sendQueue keeps both message classes in one queue, so the client gets them
+// in the order they were sent, a player update never overtakes the track
+// start it follows. Each class is still limited on its own and overflows by
+// its own policy.
+type sendQueue struct {
+	mutex  sync.Mutex
+	items  *list.List
+	counts [2]int
+	limits [2]int
+	// Has a value while there is something to take.
+	ready chan struct{}
+	// Closed and replaced whenever a message is taken, wakes blocked senders.
+	space chan struct{}
+}
+
+type queuedMessage struct {
+	msg   any
+	class messageClass
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		items:  list.New(),
+		limits: [2]int{classEvent: EVENT_QUEUE_SIZE, classState: STATE_QUEUE_SIZE},
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}),
+	}
+}
+
+// push reports whether the message was queued.
+func (q *sendQueue) push(msg any, class messageClass, policy OverflowPolicy, closed <-chan struct{}) bool {
+	var timer *time.Timer
+	for {
+		q.mutex.Lock()
+		if q.counts[class] < q.limits[class] {
+			q.append(msg, class)
+			q.mutex.Unlock()
+			return true
+		}
+
+		switch policy.Mode {
+		case OverflowDropOldest:
+			q.removeOldest(class)
+			q.append(msg, class)
+			q.mutex.Unlock()
+			return true
+		case OverflowBlock:
+			space := q.space
+			q.mutex.Unlock()
+
+			if timer == nil {
+				timer = time.NewTimer(policy.Wait)
+				defer timer.Stop()
+			}
+			select {
+			case <-space:
+				continue
+			case <-timer.C:
+			case <-closed:
+			}
+			return false
+		}
+
+		q.mutex.Unlock()
+		return false
+	}
+}
+
+// append is called with the mutex held.
+func (q *sendQueue) append(msg any, class messageClass) {
+	q.items.PushBack(queuedMessage{msg: msg, class: class})
+	q.counts[class]++
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// removeOldest is called with the mutex held.
+func (q *sendQueue) removeOldest(class messageClass) {
+	for el := q.items.Front(); el != nil; el = el.Next() {
+		if el.Value.(queuedMessage).class == class {
+			q.items.Remove(el)
+			q.counts[class]--
+			return
+		}
+	}
+}
+
+func (q *sendQueue) pop() (any, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	front := q.items.Front()
+	if front == nil {
+		return nil, false
+	}
+	item := q.items.Remove(front).(queuedMessage)
+	q.counts[item.class]--
+
+	close(q.space)
+	q.space = make(chan struct{})
+	return item.msg, true
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func drainQueue(q *sendQueue) []any {
+	var messages []any
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			return messages
+		}
+		messages = append(messages, msg)
+	}
+}
+
+func TestSendQueueKeepsOrderAcrossClasses(t *testing.T) {
+	q := newSendQueue()
+	policy := OverflowPolicy{Mode: OverflowDropNewest}
+	q.push("track start", classEvent, policy, nil)
+	q.push("player update", classState, policy, nil)
+	q.push("track end", classEvent, policy, nil)
+
+	got := drainQueue(q)
+	want := []any{"track start", "player update", "track end"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSendQueueDropOldestOnlyDropsItsClass(t *testing.T) {
+	q := newSendQueue()
+	q.limits[classState] = 2
+	events := OverflowPolicy{Mode: OverflowDropNewest}
+	state := OverflowPolicy{Mode: OverflowDropOldest}
+
+	q.push("event", classEvent, events, nil)
+	q.push("state 1", classState, state, nil)
+	q.push("state 2", classState, state, nil)
+	if !q.push("state 3", classState, state, nil) {
+		t.Fatal("drop oldest always queues the new message")
+	}
+
+	got := drainQueue(q)
+	want := []any{"event", "state 2", "state 3"}
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSendQueueDropNewest(t *testing.T) {
+	q := newSendQueue()
+	q.limits[classEvent] = 1
+	policy := OverflowPolicy{Mode: OverflowDropNewest}
+
+	q.push("first", classEvent, policy, nil)
+	if q.push("second", classEvent, policy, nil) {
+		t.Fatal("a full class drops the new message")
+	}
+	if got := drainQueue(q); len(got) != 1 || got[0] != "first" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestSendQueueBlockWaitsForRoom(t *testing.T) {
+	q := newSendQueue()
+	q.limits[classEvent] = 1
+	policy := OverflowPolicy{Mode: OverflowBlock, Wait: time.Second}
+	q.push("first", classEvent, policy, nil)
+
+	queued := make(chan bool)
+	go func() {
+		queued <- q.push("second", classEvent, policy, nil)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	q.pop()
+	if !<-queued {
+		t.Fatal("blocked send should get the freed slot")
+	}
+}
+
+func TestSendQueueBlockGivesUp(t *testing.T) {
+	q := newSendQueue()
+	q.limits[classEvent] = 1
+	policy := OverflowPolicy{Mode: OverflowBlock, Wait: 10 * time.Millisecond}
+	q.push("first", classEvent, policy, nil)
+
+	if q.push("second", classEvent, policy, nil) {
+		t.Fatal("send should give up after the wait")
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// SetTrustedProxies must be called before the server accepts connections.
+// Forwarding headers are only believed from peers in these ranges, anyone else
+// could simply make them up.
+func (s *Server) SetTrustedProxies(proxies []netip.Prefix) {
+	s.trustedProxies = proxies
+}
+
+func (s *Server) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr is the address of whoever opened the request, looking through
+// trusted proxies. X-Forwarded-For is walked from the right, as each proxy
+// appends the peer it saw, and the first hop that isn't a trusted proxy is
+// the client.
+func (s *Server) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !s.isTrustedProxy(peer) {
+		return r.RemoteAddr
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// Whatever is left of a malformed entry can't be trusted.
+				break
+			}
+			if i == 0 || !s.isTrustedProxy(hop) {
+				return hop.String()
+			}
+		}
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/shi-gg/linkdave/server/audio/source"
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+const (
+	// Playlists are added in one request, the limit keeps one from being read
+	// into memory whole before the queue limit could reject it.
+	DEFAULT_MAX_QUEUE_ADD_ITEMS = 1000
+
+	// The item limit alone doesn't bound the body, a single item can be huge.
+	MAX_QUEUE_ADD_BYTES = 8 << 20
+	// Room for everything in a track request besides inline audio.
+	MAX_TRACK_BODY_BYTES = 64 << 10
+)
+
+var ErrTooManyItems = errors.New("too_many_items")
+
+// SetMaxQueueAddItems must be called before the server accepts connections, 0
+// accepts any number of tracks per request.
+func (s *Server) SetMaxQueueAddItems(items int) {
+	s.maxQueueAdd = items
+}
+
+// trackBodyLimit fits one track with the largest data URL the node accepts.
+func trackBodyLimit() int64 {
+	limit := int64(MAX_TRACK_BODY_BYTES)
+	if cfg := source.GetConfig(); cfg.DataURLEnabled {
+		limit += int64(base64.StdEncoding.EncodedLen(cfg.DataURLMaxBytes))
+	}
+	return limit
+}
+
+// writeBodyError answers a request body that couldn't be decoded, 413 when
+// it was cut off by http.MaxBytesReader.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, protocol.ErrorResponse{Error: fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit)})
+		return
+	}
+	writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid request body"})
+}
+
+// decodeQueueAdd reads the items one at a time and stops at the first one
+// past maxItems, so an oversized playlist costs at most maxItems in memory.
+func decodeQueueAdd(r io.Reader, maxItems int) (protocol.RequestQueueAdd, error) {
+	var add protocol.RequestQueueAdd
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return add, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return add, err
+		}
+		if key != "items" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return add, err
+			}
+			continue
+		}
+
+		// A repeated key replaces the items, as with json.Unmarshal.
+		add.Items = nil
+		token, err := dec.Token()
+		if err != nil {
+			return add, err
+		}
+		if token == nil {
+			continue
+		}
+		if token != json.Delim('[') {
+			return add, fmt.Errorf("items must be an array, got %v", token)
+		}
+		for dec.More() {
+			if maxItems > 0 && len(add.Items) >= maxItems {
+				return add, fmt.Errorf("%w: at most %d tracks can be added at once", ErrTooManyItems, maxItems)
+			}
+			var item protocol.QueueItem
+			if err := dec.Decode(&item); err != nil {
+				return add, err
+			}
+			add.Items = append(add.Items, item)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return add, err
+		}
+	}
+	return add, expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %s, got %v", delim, token)
+	}
+	return nil
+}
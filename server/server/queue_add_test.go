@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeQueueAddStopsPastMaxItems(t *testing.T) {
+	body := `{"items":[{"url":"https://a"},{"url":"https://b"},{"url":"https://c"}]}`
+
+	if _, err := decodeQueueAdd(strings.NewReader(body), 2); !errors.Is(err, ErrTooManyItems) {
+		t.Fatalf("err = %v, want ErrTooManyItems", err)
+	}
+
+	add, err := decodeQueueAdd(strings.NewReader(body), 3)
+	if err != nil || len(add.Items) != 3 {
+		t.Fatalf("decodeQueueAdd = (%d items, %v), want 3 items", len(add.Items), err)
+	}
+}
+
+func TestOversizedBodyIsRequestEntityTooLarge(t *testing.T) {
+	body := `{"items":[{"url":"https://` + strings.Repeat("a", 1024) + `"}]}`
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+	_, err := decodeQueueAdd(http.MaxBytesReader(w, r.Body, 512), 0)
+	writeBodyError(w, err)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+}
@@ -1,44 +1,96 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/audio/filter"
+	"github.com/shi-gg/linkdave/server/audio/source"
 	"github.com/shi-gg/linkdave/server/protocol"
+	"github.com/shi-gg/linkdave/server/voice"
 )
 
 var startTime = time.Now()
 
+const (
+	// Stays below the HTTP server's write timeout so the response can still be sent.
+	MAX_CONNECT_TIMEOUT = 10 * time.Second
+	// A full filter chain with every equalizer band set fits several times over.
+	MAX_FILTERS_BODY_BYTES = 4 << 10
+)
+
 func (s *Server) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/health", s.routeHealth)
+	mux.HandleFunc("/ready", s.routeReady)
 	mux.HandleFunc("/stats", s.routeStats)
+	mux.HandleFunc("GET /admin/sources", s.withAuth(s.routeSourceConfig))
+	mux.HandleFunc("PATCH /admin/sources", s.withAuth(s.routeSourceConfigUpdate))
+	mux.HandleFunc("GET /admin/clients", s.withAuth(s.routeClients))
+	mux.HandleFunc("GET /admin/connections", s.withAuth(s.routeConnections))
+	mux.HandleFunc("GET /admin/snapshot", s.withAuth(s.routeSnapshot))
+	mux.HandleFunc("POST /admin/connections/{session_id}/{guild_id}/recording", s.withSession(s.routeRecordingStart))
+	mux.HandleFunc("DELETE /admin/connections/{session_id}/{guild_id}/recording", s.withSession(s.routeRecordingStop))
 	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/play", s.withSession(s.routePlay))
 	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/pause", s.withSession(s.routePause))
 	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/resume", s.withSession(s.routeResume))
 	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/stop", s.withSession(s.routeStop))
 	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/seek", s.withSession(s.routeSeek))
+	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/seek/relative", s.withSession(s.routeSeekRelative))
+	mux.HandleFunc("PUT /sessions/{session_id}/players/{guild_id}/filters", s.withSession(s.routeFilters))
+	mux.HandleFunc("PUT /sessions/{session_id}/players/{guild_id}/speaking", s.withSession(s.routeSpeaking))
+	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/playnow", s.withSession(s.routePlayNow))
+	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/reload", s.withSession(s.routeReload))
+	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/queue", s.withSession(s.routeQueueAdd))
+	mux.HandleFunc("DELETE /sessions/{session_id}/players/{guild_id}/queue", s.withSession(s.routeQueueClear))
+	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/queue/skip", s.withSession(s.routeQueueSkip))
+	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/queue/shuffle", s.withSession(s.routeQueueShuffle))
+	mux.HandleFunc("POST /sessions/{session_id}/players/{guild_id}/queue/move", s.withSession(s.routeQueueMove))
+	mux.HandleFunc("DELETE /sessions/{session_id}/players/{guild_id}/queue/{index}", s.withSession(s.routeQueueRemove))
+	mux.HandleFunc("PATCH /sessions/{session_id}/players/{guild_id}", s.withSession(s.routeUpdatePlayer))
 	mux.HandleFunc("DELETE /sessions/{session_id}/players/{guild_id}", s.withSession(s.routeDisconnect))
+	mux.HandleFunc("DELETE /sessions/{session_id}/players", s.withClient(s.routeDisconnectAll))
+	mux.HandleFunc("POST /sessions/{session_id}/batch", s.withClient(s.routeBatch))
 }
 
 type sessionHandler func(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request)
 
-func (s *Server) withSession(next sessionHandler) http.HandlerFunc {
+func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if s.password != "" && r.Header.Get("Authorization") != "Bearer "+s.password {
 			writeJSON(w, http.StatusUnauthorized, protocol.ErrorResponse{Error: "Unauthorized"})
 			return
 		}
 
-		sessionID := r.PathValue("session_id")
-		guildIDStr := r.PathValue("guild_id")
+		next(w, r)
+	}
+}
 
-		client := s.getClientBySession(sessionID)
+func (s *Server) withSession(next sessionHandler) http.HandlerFunc {
+	return s.withClient(func(client *Client, w http.ResponseWriter, r *http.Request) {
+		guildID, err := snowflake.Parse(r.PathValue("guild_id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid guild_id"})
+			return
+		}
+
+		next(client, guildID, w, r)
+	})
+}
+
+// withClient is withSession for routes that aren't about a single guild.
+func (s *Server) withClient(next func(client *Client, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return s.withAuth(func(w http.ResponseWriter, r *http.Request) {
+		client := s.getClientBySession(r.PathValue("session_id"))
 		if client == nil {
 			writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "session not found"})
 			return
@@ -51,17 +103,41 @@ func (s *Server) withSession(next sessionHandler) http.HandlerFunc {
 		default:
 		}
 
-		guildID, err := snowflake.Parse(guildIDStr)
-		if err != nil {
-			writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid guild_id"})
-			return
-		}
+		next(client, w, r)
+	})
+}
 
-		next(client, guildID, w, r)
+func (s *Server) routeHealth(w http.ResponseWriter, _ *http.Request) {
+	// A header, as the healthcheck expects an empty response.
+	if s.nodeName != "" {
+		w.Header().Set("X-Linkdave-Node", s.nodeName)
+	}
+
+	opusVersion, err := source.CheckOpus()
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, protocol.ErrorResponse{Error: err.Error()})
+		return
 	}
+	w.Header().Set("X-Linkdave-Opus", opusVersion)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *Server) routeHealth(w http.ResponseWriter, _ *http.Request) {
+// routeReady tells load balancers whether to send new players here. Unlike
+// /health, which the container healthcheck probes, failing it must not get
+// the node restarted, its players keep playing.
+func (s *Server) routeReady(w http.ResponseWriter, _ *http.Request) {
+	if s.nodeName != "" {
+		w.Header().Set("X-Linkdave-Node", s.nodeName)
+	}
+
+	if s.IsOverloaded() {
+		writeJSON(w, http.StatusServiceUnavailable, protocol.ErrorResponse{Error: ErrNodeOverloaded.Error()})
+		return
+	}
+	if s.IsDraining() {
+		writeJSON(w, http.StatusServiceUnavailable, protocol.ErrorResponse{Error: "node_draining"})
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -70,20 +146,116 @@ func (s *Server) routeStats(w http.ResponseWriter, _ *http.Request) {
 	runtime.ReadMemStats(&memStats)
 
 	response := protocol.StatsResponse{
+		Node:         s.nodeName,
 		Version:      s.version,
 		Runtime:      runtime.Version(),
 		Uptime:       time.Since(startTime).Milliseconds(),
 		NumGoroutine: runtime.NumGoroutine(),
 		Memory:       memStats.Alloc,
+		Bandwidth:    s.voiceManager.TotalBandwidth(),
+
+		OpenCircuitBreakers: s.voiceManager.OpenCircuitBreakers(),
+		QueuedConnects:      s.voiceManager.QueuedConnects(),
+		Sources:             s.voiceManager.TotalSourceStats(),
+		Buffers:             s.voiceManager.TotalBuffers(),
+		Overloaded:          s.IsOverloaded(),
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
+func (s *Server) routeClients(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.ClientStats())
+}
+
+func (s *Server) routeConnections(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.voiceManager.Connections())
+}
+
+func (s *Server) routeSnapshot(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.Snapshot())
+}
+
+func (s *Server) routeRecordingStart(client *Client, guildID snowflake.ID, w http.ResponseWriter, _ *http.Request) {
+	if err := s.voiceManager.StartRecording(client.sessionID, guildID); err != nil {
+		s.writeRecordingError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) routeRecordingStop(client *Client, guildID snowflake.ID, w http.ResponseWriter, _ *http.Request) {
+	if err := s.voiceManager.StopRecording(client.sessionID, guildID); err != nil {
+		s.writeRecordingError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) writeRecordingError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, voice.ErrRecordingDisabled):
+		status = http.StatusForbidden
+	case errors.Is(err, voice.ErrNoConnection):
+		status = http.StatusNotFound
+	case errors.Is(err, voice.ErrAlreadyRecording), errors.Is(err, voice.ErrNotRecording):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, protocol.ErrorResponse{Error: err.Error()})
+}
+
+func (s *Server) routeSourceConfig(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, sourceConfigResponse(source.GetConfig()))
+}
+
+func (s *Server) routeSourceConfigUpdate(w http.ResponseWriter, r *http.Request) {
+	var update protocol.RequestSourceConfig
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	cfg := source.UpdateConfig(func(c *source.Config) {
+		setIfPresent(&c.HTTPEnabled, update.HTTPEnabled)
+		setIfPresent(&c.HTTPSEnabled, update.HTTPSEnabled)
+		setIfPresent(&c.PublicIPAddressEnabled, update.PublicIPAddressEnabled)
+		setIfPresent(&c.PrivateIPAddressEnabled, update.PrivateIPAddressEnabled)
+		setIfPresent(&c.TextToSpeechEnabled, update.TextToSpeechEnabled)
+		setIfPresent(&c.DataURLEnabled, update.DataURLEnabled)
+	})
+
+	s.logger.Warn("source config updated", slog.Any("config", sourceConfigResponse(cfg)))
+
+	writeJSON(w, http.StatusOK, sourceConfigResponse(cfg))
+}
+
+func sourceConfigResponse(cfg source.Config) protocol.SourceConfig {
+	return protocol.SourceConfig{
+		HTTPEnabled:             cfg.HTTPEnabled,
+		HTTPSEnabled:            cfg.HTTPSEnabled,
+		PublicIPAddressEnabled:  cfg.PublicIPAddressEnabled,
+		PrivateIPAddressEnabled: cfg.PrivateIPAddressEnabled,
+		TextToSpeechEnabled:     cfg.TextToSpeechEnabled,
+		DataURLEnabled:          cfg.DataURLEnabled,
+	}
+}
+
+func setIfPresent[T any](dst *T, value *T) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
 func (s *Server) routePlay(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
 	var play protocol.RequestPlay
-	if err := json.NewDecoder(r.Body).Decode(&play); err != nil {
-		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid request body"})
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, trackBodyLimit())).Decode(&play); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if play.ConnectTimeout > 0 && !s.waitForConnection(r.Context(), client, guildID, play.ConnectTimeout) {
+		writeJSON(w, http.StatusGatewayTimeout, protocol.ErrorResponse{Error: "voice connection was not established in time"})
 		return
 	}
 
@@ -93,7 +265,7 @@ func (s *Server) routePlay(client *Client, guildID snowflake.ID, w http.Response
 		return
 	}
 
-	if err := play.Filters.Validate(); err != nil {
+	if err := play.Validate(); err != nil {
 		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -101,33 +273,488 @@ func (s *Server) routePlay(client *Client, guildID snowflake.ID, w http.Response
 
 	s.logger.Info("play requested",
 		slog.String("guild_id", guildID.String()),
-		slog.String("url", play.URL),
+		trackAttr(play.URL, play.Title, play.RequesterID),
 	)
 
-	source, err := s.voiceManager.Play(context.Background(), client.sessionID, guildID, play.URL, play.StartTime, play.Filters)
+	mode := cmp.Or(play.Mode, client.options.Defaults.PlayMode)
+	if mode == protocol.PlayModeEnqueue {
+		position, queued, err := player.EnqueueIfActive(play.QueueItem)
+		if err != nil {
+			writeJSON(w, http.StatusConflict, protocol.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if queued {
+			client.sendQueueUpdate(guildID, player)
+			writeJSON(w, http.StatusAccepted, protocol.PlayResponse{Mode: mode, QueuePosition: position})
+			return
+		}
+	}
+
+	position, err := s.playRequested(r.Context(), client, guildID, player, play.QueueItem, false)
 	if err != nil {
-		s.logger.Error("playback failed", slog.Any("error", err))
-		writeJSON(w, http.StatusInternalServerError, protocol.ErrorResponse{Error: err.Error()})
+		s.writePlaybackError(w, err)
 		return
 	}
 
-	player.SetPlayingState(play.URL, play.StartTime, play.RequesterID, play.Filters)
+	if play.StartTime > 0 {
+		writeJSON(w, http.StatusOK, protocol.NewSeekResponse(play.StartTime, position))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// playItem returns where playback started, which can be short of the item's
+// start time.
+func (s *Server) playItem(ctx context.Context, client *Client, guildID snowflake.ID, player *Player, item protocol.QueueItem, paused bool) (int64, error) {
+	return s.playItemWith(ctx, client, guildID, player, item, paused, player.GetVolume(), player.GetBufferMs())
+}
+
+// playItemWith starts the track with volume and bufferMs instead of the
+// player's own, for updates that only keep them once the track started.
+func (s *Server) playItemWith(setup context.Context, client *Client, guildID snowflake.ID, player *Player, item protocol.QueueItem, paused bool, volume int, bufferMs *int) (int64, error) {
+	if item.Filters == nil {
+		item.Filters = cmp.Or(player.GetNextFilters(), client.options.Defaults.Filters)
+	}
+
+	ctx, pending := player.beginPlay(setup)
+	defer player.endPlay(pending)
+	// Replaced by a newer play, or out of time, while waiting for the one before.
+	if ctx.Err() != nil {
+		return 0, setupError(setup)
+	}
+
+	src, err := s.voiceManager.Play(ctx, client.sessionID, guildID, item.URL, source.Options{
+		StartTimeMs: item.StartTime,
+		Filters:     item.Filters,
+		Normalize:   item.Normalize,
+		Volume:      &volume,
+		BufferMs:    bufferMs,
+		Bitrate:     item.Bitrate,
+		Signal:      item.Signal,
+	}, paused)
+	if errors.Is(err, voice.ErrPlaybackSuperseded) {
+		return 0, setupError(setup)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	item.StartTime = src.Position()
+	player.SetPlayingState(item)
+	if paused {
+		player.SetPausedState(item.StartTime)
+	}
+	client.sendStateChange(guildID, player)
 
 	client.send(protocol.Message{
 		Op: protocol.OpTrackStart,
 		Data: protocol.TrackStartData{
 			GuildID: guildID,
 			Track: protocol.TrackInfo{
-				URL:         source.URL(),
-				Duration:    source.Duration(),
-				RequesterID: play.RequesterID,
+				URL:         src.URL(),
+				Title:       item.Title,
+				Duration:    src.Duration(),
+				RequesterID: item.RequesterID,
+				Bitrate:     src.Bitrate(),
 			},
 		},
 	})
 
+	return item.StartTime, nil
+}
+
+// setupError tells a play canceled for running out of setup time, e.g. past
+// a batch's deadline, from one replaced by a newer play.
+func setupError(setup context.Context) error {
+	if errors.Is(setup.Err(), context.DeadlineExceeded) {
+		return setup.Err()
+	}
+	return voice.ErrPlaybackSuperseded
+}
+
+func (s *Server) writePlaybackError(w http.ResponseWriter, err error) {
+	if errors.Is(err, voice.ErrPlaybackSuperseded) {
+		writeJSON(w, http.StatusConflict, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSON(w, http.StatusGatewayTimeout, protocol.ErrorResponse{Error: "play timed out while loading"})
+		return
+	}
+	if errors.Is(err, ErrNodeOverloaded) {
+		writeJSON(w, http.StatusServiceUnavailable, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, source.ErrDataURLTooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, source.ErrNetwork) {
+		writeJSON(w, http.StatusBadGateway, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, source.ErrEmptyStream) || errors.Is(err, source.ErrUnsupportedFormat) {
+		writeJSON(w, http.StatusUnprocessableEntity, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	s.logger.Error("playback failed", slog.Any("error", err))
+	writeJSON(w, http.StatusInternalServerError, protocol.ErrorResponse{Error: err.Error()})
+}
+
+// routeUpdatePlayer restores a whole player in one request, e.g. after a
+// reconnect or migration, instead of replaying play, seek and filters.
+func (s *Server) routeUpdatePlayer(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	var update protocol.RequestUpdatePlayer
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, trackBodyLimit())).Decode(&update); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	if err := update.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if update.Track != nil {
+		s.updatePlayerTrack(client, guildID, player, update, w, r)
+		return
+	}
+
+	// Like Lavalink, filters sent to an idle player apply to its next track.
+	idle := player.GetState() == protocol.PlayerStateIdle
+	if idle && update.Position != nil {
+		writeJSON(w, http.StatusConflict, protocol.ErrorResponse{Error: voice.ErrNoPlayback.Error()})
+		return
+	}
+	voiceUpdate := voice.PlayerUpdate{
+		Position:     update.Position,
+		Paused:       update.Paused,
+		Volume:       update.Volume,
+		VolumeRampMs: update.VolumeRampMs,
+	}
+	if !idle {
+		voiceUpdate.Filters = update.Filters
+	}
+
+	var err error
+	if voiceUpdate != (voice.PlayerUpdate{}) {
+		err = s.voiceManager.Update(client.sessionID, guildID, voiceUpdate)
+	}
+	if err != nil {
+		if errors.Is(err, voice.ErrNoPlayback) {
+			writeJSON(w, http.StatusConflict, protocol.ErrorResponse{Error: err.Error()})
+			return
+		}
+		s.logger.Error("failed to update player", slog.Any("error", err))
+		writeJSON(w, http.StatusInternalServerError, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	applyPlayerSettings(player, update)
+	switch {
+	case update.Filters == nil:
+	case idle:
+		player.SetNextFilters(update.Filters)
+	default:
+		player.SetFilters(update.Filters)
+	}
+	if !idle {
+		s.syncPlayback(client, guildID, player, update)
+	}
+
+	client.sendPlayerUpdate(guildID, player)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyPlayerSettings keeps the settings of an update that succeeded.
+func applyPlayerSettings(player *Player, update protocol.RequestUpdatePlayer) {
+	if update.Volume != nil {
+		player.SetVolume(*update.Volume)
+	}
+	if update.BufferMs != nil {
+		player.SetBufferMs(*update.BufferMs)
+	}
+}
+
+func (s *Server) syncPlayback(client *Client, guildID snowflake.ID, player *Player, update protocol.RequestUpdatePlayer) {
+	position := s.voiceManager.Position(client.sessionID, guildID)
+	if update.Paused != nil && *update.Paused {
+		player.SetPausedState(position)
+		return
+	}
+	if update.Paused != nil {
+		player.SetResumedState(position)
+		return
+	}
+
+	player.SetPosition(position)
+}
+
+func (s *Server) updatePlayerTrack(client *Client, guildID snowflake.ID, player *Player, update protocol.RequestUpdatePlayer, w http.ResponseWriter, r *http.Request) {
+	item := *update.Track
+	if update.Position != nil {
+		item.StartTime = *update.Position
+	}
+	if update.Filters != nil {
+		item.Filters = update.Filters
+	}
+	item.Filters = item.Filters.Normalize()
+
+	// The track starts with the new settings, but the player only keeps them
+	// once it did.
+	volume, bufferMs := player.GetVolume(), player.GetBufferMs()
+	if update.Volume != nil {
+		volume = *update.Volume
+	}
+	if update.BufferMs != nil {
+		bufferMs = update.BufferMs
+	}
+
+	paused := update.Paused != nil && *update.Paused
+	if _, err := s.playRequestedWith(r.Context(), client, guildID, player, item, paused, volume, bufferMs); err != nil {
+		s.writePlaybackError(w, err)
+		return
+	}
+
+	applyPlayerSettings(player, update)
+	client.sendPlayerUpdate(guildID, player)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routePlayNow interrupts the current track and puts it back at the head of
+// the queue at its current position, so it resumes once the new one is done.
+func (s *Server) routePlayNow(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	var play protocol.QueueItem
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, trackBodyLimit())).Decode(&play); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	if err := play.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	play.Filters = play.Filters.Normalize()
+
+	interrupted, ok := player.GetCurrentItem(s.voiceManager.Position(client.sessionID, guildID))
+
+	if _, err := s.playRequested(r.Context(), client, guildID, player, play, false); err != nil {
+		s.writePlaybackError(w, err)
+		return
+	}
+
+	// Only queued once the new track plays, a failed play leaves the queue as it was.
+	if ok {
+		player.PushFrontQueue(interrupted)
+		client.sendQueueUpdate(guildID, player)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeReload gets a stalled stream going again without the client having to
+// resend the track. Streams that can't seek restart at the live edge either way.
+func (s *Server) routeReload(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	var reload protocol.RequestReload
+	if err := json.NewDecoder(r.Body).Decode(&reload); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	var position int64
+	if !reload.FromStart {
+		position = s.voiceManager.Position(client.sessionID, guildID)
+	}
+	item, ok := player.GetCurrentItem(position)
+	if !ok {
+		writeJSON(w, http.StatusConflict, protocol.ErrorResponse{Error: "no track is playing"})
+		return
+	}
+
+	s.logger.Info("reload requested",
+		slog.String("guild_id", guildID.String()),
+		trackAttr(item.URL, item.Title, item.RequesterID),
+		slog.Int64("position", position),
+	)
+
+	paused := player.GetState() == protocol.PlayerStatePaused
+	if _, err := s.playRequested(r.Context(), client, guildID, player, item, paused); err != nil {
+		s.writePlaybackError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) routeQueueAdd(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, max(MAX_QUEUE_ADD_BYTES, trackBodyLimit()))
+	add, err := decodeQueueAdd(body, s.maxQueueAdd)
+	if errors.Is(err, ErrTooManyItems) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	for i := range add.Items {
+		if err := add.Items[i].Validate(); err != nil {
+			writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: err.Error()})
+			return
+		}
+		add.Items[i].Filters = add.Items[i].Filters.Normalize()
+	}
+
+	if err := player.AddToQueue(add.Items...); err != nil {
+		writeJSON(w, http.StatusConflict, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	client.sendQueueUpdate(guildID, player)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeQueueClear only drops the upcoming tracks, the current one keeps
+// playing unlike with stop.
+func (s *Server) routeQueueClear(client *Client, guildID snowflake.ID, w http.ResponseWriter, _ *http.Request) {
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	player.ClearQueue()
+	client.sendQueueUpdate(guildID, player)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) routeQueueShuffle(client *Client, guildID snowflake.ID, w http.ResponseWriter, _ *http.Request) {
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	player.ShuffleQueue()
+	client.sendQueueUpdate(guildID, player)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) routeQueueMove(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	var move protocol.RequestQueueMove
+	if err := json.NewDecoder(r.Body).Decode(&move); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	if err := player.MoveInQueue(move.From, move.To); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	client.sendQueueUpdate(guildID, player)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (s *Server) routeQueueRemove(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid index"})
+		return
+	}
+
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	if err := player.RemoveFromQueue(index); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	client.sendQueueUpdate(guildID, player)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) routeQueueSkip(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
+
+	// Checked before the track leaves the queue, so it isn't lost.
+	if s.IsOverloaded() {
+		s.writePlaybackError(w, ErrNodeOverloaded)
+		return
+	}
+
+	item, ok := player.PopQueue()
+	if !ok {
+		writeJSON(w, http.StatusConflict, protocol.ErrorResponse{Error: "queue is empty"})
+		return
+	}
+	client.sendQueueUpdate(guildID, player)
+
+	if _, err := s.playRequested(r.Context(), client, guildID, player, item, false); err != nil {
+		s.writePlaybackError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The player is created right after the voice connection is stored, so it may
+// still be missing for a moment after the connection is ready.
+func (s *Server) waitForConnection(ctx context.Context, client *Client, guildID snowflake.ID, timeoutMs int64) bool {
+	ctx, cancel := context.WithTimeout(ctx, min(time.Duration(timeoutMs)*time.Millisecond, MAX_CONNECT_TIMEOUT))
+	defer cancel()
+
+	if err := s.voiceManager.WaitForConnection(ctx, client.sessionID, guildID); err != nil {
+		s.logger.Warn("voice connection not established before play", slog.String("guild_id", guildID.String()))
+		return false
+	}
+
+	client.getOrCreatePlayer(guildID)
+	return true
+}
+
 func (s *Server) routePause(client *Client, guildID snowflake.ID, w http.ResponseWriter, _ *http.Request) {
 	player := client.getPlayer(guildID)
 	if player == nil {
@@ -143,13 +770,7 @@ func (s *Server) routePause(client *Client, guildID snowflake.ID, w http.Respons
 
 	player.SetPausedState(s.voiceManager.Position(client.sessionID, guildID))
 
-	client.send(protocol.Message{
-		Op: protocol.OpPlayerUpdate,
-		Data: protocol.PlayerUpdateData{
-			GuildID: guildID,
-			State:   player.GetState(),
-		},
-	})
+	client.sendPlayerUpdate(guildID, player)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -167,17 +788,9 @@ func (s *Server) routeResume(client *Client, guildID snowflake.ID, w http.Respon
 		return
 	}
 
-	player.SetState(protocol.PlayerStatePlaying)
-	player.SetStartedAt(time.Now())
-	player.SetPosition(s.voiceManager.Position(client.sessionID, guildID))
+	player.SetResumedState(s.voiceManager.Position(client.sessionID, guildID))
 
-	client.send(protocol.Message{
-		Op: protocol.OpPlayerUpdate,
-		Data: protocol.PlayerUpdateData{
-			GuildID: guildID,
-			State:   player.GetState(),
-		},
-	})
+	client.sendPlayerUpdate(guildID, player)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -189,6 +802,7 @@ func (s *Server) routeStop(client *Client, guildID snowflake.ID, w http.Response
 		return
 	}
 
+	player.CancelPlay()
 	if err := s.voiceManager.Stop(client.sessionID, guildID); err != nil {
 		s.logger.Error("failed to stop", slog.Any("error", err))
 		writeJSON(w, http.StatusInternalServerError, protocol.ErrorResponse{Error: err.Error()})
@@ -197,13 +811,7 @@ func (s *Server) routeStop(client *Client, guildID snowflake.ID, w http.Response
 
 	player.SetIdleState()
 
-	client.send(protocol.Message{
-		Op: protocol.OpPlayerUpdate,
-		Data: protocol.PlayerUpdateData{
-			GuildID: guildID,
-			State:   player.GetState(),
-		},
-	})
+	client.sendPlayerUpdate(guildID, player)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -221,20 +829,119 @@ func (s *Server) routeSeek(client *Client, guildID snowflake.ID, w http.Response
 		return
 	}
 
-	if err := s.voiceManager.Seek(client.sessionID, guildID, seek.Position); err != nil {
-		s.logger.Error("failed to seek", slog.Any("error", err))
+	result, err := s.voiceManager.Seek(client.sessionID, guildID, seek.Position)
+	if err != nil {
+		s.writeSeekError(w, err)
+		return
+	}
+
+	player.SetPosition(result.Position)
+
+	writeJSON(w, http.StatusOK, protocol.NewSeekResponse(seek.Position, result.Position))
+}
+
+func (s *Server) routeSeekRelative(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	var seek protocol.RequestSeekRelative
+	if err := json.NewDecoder(r.Body).Decode(&seek); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
 
-		if strings.Contains(err.Error(), "not supported") {
-			writeJSON(w, http.StatusNotImplemented, protocol.ErrorResponse{Error: err.Error()})
+	result, err := s.voiceManager.SeekBy(client.sessionID, guildID, seek.Delta)
+	if err != nil {
+		s.writeSeekError(w, err)
+		return
+	}
+
+	player.SetPosition(result.Position)
+
+	writeJSON(w, http.StatusOK, protocol.NewSeekResponse(result.Requested, result.Position))
+}
+
+func (s *Server) writeSeekError(w http.ResponseWriter, err error) {
+	if errors.Is(err, voice.ErrNoPlayback) {
+		writeJSON(w, http.StatusConflict, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	s.logger.Error("failed to seek", slog.Any("error", err))
+
+	if strings.Contains(err.Error(), "not supported") {
+		writeJSON(w, http.StatusNotImplemented, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusInternalServerError, protocol.ErrorResponse{Error: err.Error()})
+}
+
+func (s *Server) routeFilters(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	// Unknown keys are rejected rather than ignored, otherwise a typo would
+	// silently replace the whole chain with a partial one.
+	var filters filter.Filters
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_FILTERS_BODY_BYTES))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&filters); err != nil {
+		if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+			writeBodyError(w, err)
 			return
 		}
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid filters: " + err.Error()})
+		return
+	}
+
+	player := client.getPlayer(guildID)
+	if player == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
+	}
 
+	if err := filters.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
+	normalized := filters.Normalize()
+
+	err := s.voiceManager.SetFilters(client.sessionID, guildID, normalized)
+	switch {
+	case errors.Is(err, voice.ErrNoPlayback):
+		// Kept for the next track, like filters of an idle player update.
+		player.SetNextFilters(normalized)
+	case err != nil:
+		s.logger.Error("failed to set filters", slog.Any("error", err))
 		writeJSON(w, http.StatusInternalServerError, protocol.ErrorResponse{Error: err.Error()})
 		return
+	default:
+		player.SetFilters(normalized)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) routeSpeaking(client *Client, guildID snowflake.ID, w http.ResponseWriter, r *http.Request) {
+	var speaking protocol.RequestSpeaking
+	if err := json.NewDecoder(r.Body).Decode(&speaking); err != nil {
+		writeJSON(w, http.StatusBadRequest, protocol.ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	if client.getPlayer(guildID) == nil {
+		writeJSON(w, http.StatusNotFound, protocol.ErrorResponse{Error: "player not found"})
+		return
 	}
 
-	player.SetPosition(s.voiceManager.Position(client.sessionID, guildID))
-	player.SetStartedAt(time.Now())
+	if err := s.voiceManager.SetSpeaking(r.Context(), client.sessionID, guildID, speaking.Priority, speaking.Soundshare); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, voice.ErrNoConnection) {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, protocol.ErrorResponse{Error: err.Error()})
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -242,6 +949,9 @@ func (s *Server) routeSeek(client *Client, guildID snowflake.ID, w http.Response
 func (s *Server) routeDisconnect(client *Client, guildID snowflake.ID, w http.ResponseWriter, _ *http.Request) {
 	s.logger.Info("processing disconnect", slog.String("guild_id", guildID.String()))
 
+	if player := client.getPlayer(guildID); player != nil {
+		player.CancelPlay()
+	}
 	if err := s.voiceManager.Disconnect(client.sessionID, guildID); err != nil {
 		s.logger.Error("failed to disconnect", slog.Any("error", err))
 	}
@@ -259,6 +969,28 @@ func (s *Server) routeDisconnect(client *Client, guildID snowflake.ID, w http.Re
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// routeDisconnectAll is for bot shutdowns and "leave all" commands, which
+// would otherwise take a request per guild.
+func (s *Server) routeDisconnectAll(client *Client, w http.ResponseWriter, _ *http.Request) {
+	guildIDs := client.destroyAllPlayers()
+	s.logger.Info("disconnected all players",
+		slog.String("session", client.sessionID),
+		slog.Int("players", len(guildIDs)),
+	)
+
+	for _, guildID := range guildIDs {
+		client.send(protocol.Message{
+			Op: protocol.OpVoiceDisconnect,
+			Data: protocol.VoiceDisconnectData{
+				GuildID: guildID,
+				Reason:  protocol.DisconnectReasonRequested,
+			},
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func writeJSON(w http.ResponseWriter, status int, body any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/audio/filter"
+)
+
+func TestPlayBodyIsLimited(t *testing.T) {
+	s := &Server{}
+	body := `{"url":"https://` + strings.Repeat("a", int(trackBodyLimit())) + `"}`
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+	s.routePlay(&Client{}, 1, w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+}
+
+func TestFailedTrackUpdateKeepsSettings(t *testing.T) {
+	s := &Server{}
+	s.overloaded.Store(true)
+	player := newTestPlayer()
+	player.SetVolume(80)
+	client := &Client{players: map[snowflake.ID]*Player{1: player}}
+
+	body := `{"track":{"url":"https://example.com/song.mp3"},"volume":20,"buffer_ms":5000}`
+	w := httptest.NewRecorder()
+	s.routeUpdatePlayer(client, 1, w, httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body)))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+	if volume := player.GetVolume(); volume != 80 {
+		t.Fatalf("volume = %d after a failed update, want 80", volume)
+	}
+	if bufferMs := player.GetBufferMs(); bufferMs != nil {
+		t.Fatalf("buffer = %d after a failed update, want unset", *bufferMs)
+	}
+}
+
+func TestIdleUpdateKeepsFiltersForNextTrack(t *testing.T) {
+	s := &Server{}
+	player := newTestPlayer()
+	client := &Client{server: s, queue: newSendQueue(), latency: newLatency(), players: map[snowflake.ID]*Player{1: player}}
+
+	body := `{"filters":{"enabled":[1]}}`
+	w := httptest.NewRecorder()
+	s.routeUpdatePlayer(client, 1, w, httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body)))
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", w.Code)
+	}
+	if filters := player.GetNextFilters(); filters == nil || len(filters.Enabled) != 1 || filters.Enabled[0] != filter.Nightcore {
+		t.Fatalf("next filters = %+v, want nightcore", filters)
+	}
+}
+
+func TestIdleUpdateWithPositionConflicts(t *testing.T) {
+	s := &Server{}
+	client := &Client{players: map[snowflake.ID]*Player{1: newTestPlayer()}}
+
+	w := httptest.NewRecorder()
+	s.routeUpdatePlayer(client, 1, w, httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"position":1000}`)))
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", w.Code)
+	}
+}
+
+func TestFiltersBodyIsLimited(t *testing.T) {
+	s := &Server{}
+	body := `{"equalizer":[` + strings.Repeat(`{"band":0,"gain":1},`, MAX_FILTERS_BODY_BYTES) + `{"band":0,"gain":1}]}`
+	w := httptest.NewRecorder()
+
+	s.routeFilters(&Client{}, 1, w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body)))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+}
+
+func TestFiltersRejectExtraBands(t *testing.T) {
+	s := &Server{}
+	client := &Client{players: map[snowflake.ID]*Player{1: newTestPlayer()}}
+	body := `{"equalizer":[` + strings.Repeat(`{"band":0,"gain":1},`, filter.EQUALIZER_BAND_COUNT) + `{"band":0,"gain":1}]}`
+	w := httptest.NewRecorder()
+
+	s.routeFilters(client, 1, w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
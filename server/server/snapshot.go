@@ -0,0 +1,129 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+// Bound the snapshot of a node with huge or unlimited queues, the tracks that
+// are playing are always included. Bytes count too, as a queue of data URLs is
+// large long before it is long.
+const (
+	SNAPSHOT_MAX_QUEUE_ITEMS = 100_000
+	SNAPSHOT_MAX_QUEUE_BYTES = 64 << 20
+)
+
+// snapshotBudget is shared by all players of a snapshot.
+type snapshotBudget struct {
+	items     int
+	bytes     int
+	truncated bool
+}
+
+func newSnapshotBudget() *snapshotBudget {
+	return &snapshotBudget{items: SNAPSHOT_MAX_QUEUE_ITEMS, bytes: SNAPSHOT_MAX_QUEUE_BYTES}
+}
+
+// take returns the part of queue that still fits.
+func (b *snapshotBudget) take(queue []protocol.QueueItem) []protocol.QueueItem {
+	for i, item := range queue {
+		// The strings are what makes an item large, the rest is a few bytes.
+		size := len(item.URL) + len(item.Title) + len(item.RequesterID)
+		if b.items == 0 || size > b.bytes {
+			b.truncated = true
+			return queue[:i]
+		}
+		b.items--
+		b.bytes -= size
+	}
+	return queue
+}
+
+// Snapshot holds the clients lock throughout, so no client or player comes or
+// goes halfway. Voice connections are listed right after, from the voice
+// manager.
+func (s *Server) Snapshot() protocol.NodeSnapshot {
+	snapshot := protocol.NodeSnapshot{
+		Node:    s.nodeName,
+		Version: s.version,
+	}
+	budget := newSnapshotBudget()
+
+	s.clientsMu.RLock()
+	now := s.clock.Now()
+	snapshot.Timestamp = now.UnixMilli()
+	snapshot.Clients = make([]protocol.ClientSnapshot, 0, len(s.clients))
+	for _, client := range s.clients {
+		snapshot.Clients = append(snapshot.Clients, client.snapshot(now, budget))
+	}
+	s.clientsMu.RUnlock()
+
+	snapshot.Truncated = budget.truncated
+	snapshot.Connections = s.voiceManager.Connections()
+
+	slices.SortFunc(snapshot.Clients, func(a, b protocol.ClientSnapshot) int {
+		return strings.Compare(a.SessionID, b.SessionID)
+	})
+	return snapshot
+}
+
+func (c *Client) snapshot(now time.Time, budget *snapshotBudget) protocol.ClientSnapshot {
+	c.playersMu.RLock()
+	defer c.playersMu.RUnlock()
+
+	result := protocol.ClientSnapshot{
+		SessionID: c.sessionID,
+		Name:      c.clientName,
+		Addr:      c.addr,
+		Players:   make([]protocol.PlayerSnapshot, 0, len(c.players)+len(c.detached)),
+	}
+	for _, player := range c.players {
+		result.Players = append(result.Players, player.snapshot(now, budget))
+	}
+	for _, detached := range c.detached {
+		player := detached.player.snapshot(now, budget)
+		player.Detached = true
+		if detached.resume != nil {
+			player.URL = detached.resume.URL
+			player.Title = detached.resume.Title
+			player.RequesterID = detached.resume.RequesterID
+			player.Position = detached.resume.StartTime
+			player.Filters = detached.resume.Filters
+			player.State = protocol.PlayerStatePlaying
+			if detached.paused {
+				player.State = protocol.PlayerStatePaused
+			}
+		}
+		result.Players = append(result.Players, player)
+	}
+
+	slices.SortFunc(result.Players, func(a, b protocol.PlayerSnapshot) int {
+		return cmp.Compare(a.GuildID, b.GuildID)
+	})
+	return result
+}
+
+func (p *Player) snapshot(now time.Time, budget *snapshotBudget) protocol.PlayerSnapshot {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	queue := budget.take(p.queue)
+
+	return protocol.PlayerSnapshot{
+		GuildID:     p.guildID,
+		ChannelID:   p.channelID,
+		State:       p.state,
+		URL:         p.current.URL,
+		Title:       p.current.Title,
+		RequesterID: p.current.RequesterID,
+		Position:    p.positionAt(now),
+		Volume:      p.volume,
+		Filters:     p.filters,
+		// Never nil, so an empty queue is sent as [] rather than null.
+		Queue: append([]protocol.QueueItem{}, queue...),
+	}
+}
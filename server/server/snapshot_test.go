@@ -0,0 +1,42 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/clock"
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+func TestSnapshotUsesServerClock(t *testing.T) {
+	manual := clock.NewManual(time.Unix(1_700_000_000, 0))
+	server := &Server{}
+	server.SetClock(manual)
+
+	client := &Client{
+		server:   server,
+		players:  make(map[snowflake.ID]*Player),
+		detached: make(map[snowflake.ID]*detachedPlayer),
+	}
+	player := client.getOrCreatePlayer(snowflake.ID(1))
+	player.SetPlayingState(protocol.QueueItem{URL: "https://example.com/song.mp3", StartTime: 250})
+
+	manual.Advance(90 * time.Second)
+
+	snapshot := client.snapshot(manual.Now(), newSnapshotBudget())
+	if got := snapshot.Players[0].Position; got != 90_250 {
+		t.Fatalf("position = %d, want 90250", got)
+	}
+}
+
+func TestSnapshotBudgetCountsBytes(t *testing.T) {
+	large := protocol.QueueItem{URL: "data:audio/mpeg;base64," + strings.Repeat("A", SNAPSHOT_MAX_QUEUE_BYTES/2)}
+	budget := newSnapshotBudget()
+
+	queue := budget.take([]protocol.QueueItem{large, large, large})
+	if len(queue) != 1 || !budget.truncated {
+		t.Fatalf("kept %d items, truncated = %v, want 1 and true", len(queue), budget.truncated)
+	}
+}
@@ -1,29 +1,31 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/disgoorg/snowflake/v2"
 	"github.com/gorilla/websocket"
+	"github.com/shi-gg/linkdave/server/audio/filter"
 	"github.com/shi-gg/linkdave/server/audio/source"
+	"github.com/shi-gg/linkdave/server/clock"
 	"github.com/shi-gg/linkdave/server/protocol"
 	"github.com/shi-gg/linkdave/server/voice"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for now
-	},
-}
-
 type Server struct {
 	logger       *slog.Logger
 	voiceManager *voice.Manager
@@ -34,6 +36,22 @@ type Server struct {
 	drainMu      sync.RWMutex
 	version      string
 	password     string
+	heartbeat    Heartbeat
+	sendPolicy   SendPolicy
+	clock        clock.Clock
+	playerGrace  time.Duration
+	nodeName     string
+	maxQueue     int
+	maxQueueAdd  int
+	upgrader     websocket.Upgrader
+	originPolicy OriginPolicy
+	// Empty unless the node runs behind a reverse proxy.
+	trustedProxies []netip.Prefix
+	// 0 unless SetMemoryLimit was called.
+	memoryLimit uint64
+	overloaded  atomic.Bool
+
+	migratedPlayers atomic.Int64
 }
 
 func NewServer(logger *slog.Logger, voiceManager *voice.Manager, version string, password string) *Server {
@@ -44,14 +62,53 @@ func NewServer(logger *slog.Logger, voiceManager *voice.Manager, version string,
 		startTime:    time.Now(),
 		version:      version,
 		password:     password,
+		heartbeat:    DEFAULT_HEARTBEAT,
+		sendPolicy:   DEFAULT_SEND_POLICY,
+		clock:        clock.SYSTEM,
+		maxQueue:     DEFAULT_MAX_QUEUE_LENGTH,
+		maxQueueAdd:  DEFAULT_MAX_QUEUE_ADD_ITEMS,
+		originPolicy: DEFAULT_ORIGIN_POLICY,
+	}
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.checkOrigin,
+		Subprotocols:    []string{protocol.PROTOCOL_SUBPROTOCOL},
 	}
 	voiceManager.SetEventHandler(s)
 	s.startTickers()
 	return s
 }
 
+// SetHeartbeat must be called before the server accepts connections.
+func (s *Server) SetHeartbeat(heartbeat Heartbeat) {
+	s.heartbeat = heartbeat
+}
+
+// SetClock must be called before the server accepts connections.
+func (s *Server) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetNodeName must be called before the server accepts connections, the name
+// tells operators and clients which node of a fleet they are talking to.
+func (s *Server) SetNodeName(name string) {
+	s.nodeName = name
+}
+
+// SetMaxQueueLength must be called before the server accepts connections, 0
+// lets queues grow without limit.
+func (s *Server) SetMaxQueueLength(length int) {
+	s.maxQueue = length
+}
+
+// SetSendPolicy must be called before the server accepts connections.
+func (s *Server) SetSendPolicy(policy SendPolicy) {
+	s.sendPolicy = policy
+}
+
 func (s *Server) startTickers() {
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(STATS_INTERVAL)
 	go func() {
 		for range ticker.C {
 			s.sendStats()
@@ -60,11 +117,23 @@ func (s *Server) startTickers() {
 }
 
 func (s *Server) sendStats() {
+	if !s.hasStatsSubscribers() {
+		return
+	}
+
 	stats := s.GetStats()
 	s.clientsMu.RLock()
 	defer s.clientsMu.RUnlock()
 
 	for _, client := range s.clients {
+		if !client.options.Stats {
+			continue
+		}
+		if client.statsSkip > 0 {
+			client.statsSkip--
+			continue
+		}
+		client.statsSkip = client.latency.statsTicks() - 1
 		client.send(protocol.Message{
 			Op:   protocol.OpStats,
 			Data: stats,
@@ -72,6 +141,18 @@ func (s *Server) sendStats() {
 	}
 }
 
+func (s *Server) hasStatsSubscribers() bool {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	for _, client := range s.clients {
+		if client.options.Stats {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) OnTrackEnd(sessionID string, guildID snowflake.ID, src source.Source, reason string) {
 	client := s.getClientBySession(sessionID)
 	if client == nil {
@@ -83,14 +164,17 @@ func (s *Server) OnTrackEnd(sessionID string, guildID snowflake.ID, src source.S
 		return
 	}
 
-	track := protocol.TrackInfo{
-		URL:         src.URL(),
-		Duration:    src.Duration(),
-		RequesterID: player.GetRequesterID(),
-	}
+	track := player.GetTrackInfo(src)
+
+	s.logger.Info("track ended",
+		slog.String("guild_id", guildID.String()),
+		trackAttr(track.URL, track.Title, track.RequesterID),
+		slog.String("reason", reason),
+	)
 
 	if reason != protocol.TrackEndReasonReplaced && reason != protocol.TrackEndReasonStopped {
 		player.SetIdleState()
+		client.sendStateChange(guildID, player)
 	}
 
 	client.send(protocol.Message{
@@ -99,8 +183,60 @@ func (s *Server) OnTrackEnd(sessionID string, guildID snowflake.ID, src source.S
 			GuildID: guildID,
 			Track:   track,
 			Reason:  reason,
+			Stats:   src.Stats(),
 		},
 	})
+
+	if reason == protocol.TrackEndReasonFinished || reason == protocol.TrackEndReasonError {
+		// Called from the voice send loop, which must not block on fetching the next track.
+		go s.playNext(client, guildID, player)
+	}
+}
+
+// playNext skips over queued tracks that fail to load, so one broken URL
+// doesn't stall the rest of the queue.
+func (s *Server) playNext(client *Client, guildID snowflake.ID, player *Player) {
+	for {
+		item, ok := player.PopQueue()
+		if !ok {
+			return
+		}
+		client.sendQueueUpdate(guildID, player)
+
+		_, err := s.playItem(context.Background(), client, guildID, player, item, false)
+		if err == nil || errors.Is(err, voice.ErrPlaybackSuperseded) {
+			return
+		}
+
+		s.logger.Error("failed to play next queued track",
+			slog.String("guild_id", guildID.String()),
+			trackAttr(item.URL, item.Title, item.RequesterID),
+			slog.Any("error", err),
+		)
+
+		client.send(protocol.Message{
+			Op: protocol.OpTrackError,
+			Data: protocol.TrackErrorData{
+				GuildID: guildID,
+				Track: protocol.TrackInfo{
+					URL:         item.URL,
+					Title:       item.Title,
+					RequesterID: item.RequesterID,
+				},
+				Error: err.Error(),
+			},
+		})
+	}
+}
+
+// trackAttr groups the track metadata so a complaint can be traced back to
+// the user action that started it.
+func trackAttr(url, title, requesterID string) slog.Attr {
+	return slog.Group("track",
+		slog.String("url", source.RedactURL(url)),
+		slog.String("title", title),
+		slog.String("requester_id", requesterID),
+	)
 }
 
 func (s *Server) OnTrackException(sessionID string, guildID snowflake.ID, src source.Source, err error) {
@@ -109,17 +245,20 @@ func (s *Server) OnTrackException(sessionID string, guildID snowflake.ID, src so
 		return
 	}
 
-	player := client.getPlayer(guildID)
-
 	track := protocol.TrackInfo{
 		URL:      src.URL(),
 		Duration: src.Duration(),
 	}
-
-	if player != nil {
-		track.RequesterID = player.GetRequesterID()
+	if player := client.getPlayer(guildID); player != nil {
+		track = player.GetTrackInfo(src)
 	}
 
+	s.logger.Warn("track exception",
+		slog.String("guild_id", guildID.String()),
+		trackAttr(track.URL, track.Title, track.RequesterID),
+		slog.Any("error", err),
+	)
+
 	client.send(protocol.Message{
 		Op: protocol.OpTrackError,
 		Data: protocol.TrackErrorData{
@@ -130,23 +269,78 @@ func (s *Server) OnTrackException(sessionID string, guildID snowflake.ID, src so
 	})
 }
 
-func (s *Server) OnVoiceDisconnected(sessionID string, guildID snowflake.ID) {
+func (s *Server) OnVoiceDisconnected(sessionID string, guildID snowflake.ID, reason string) {
 	client := s.getClientBySession(sessionID)
 	if client == nil {
 		return
 	}
 
-	client.removePlayer(guildID)
+	client.detachPlayer(guildID, s.playerGrace)
 
 	client.send(protocol.Message{
 		Op: protocol.OpVoiceDisconnect,
 		Data: protocol.VoiceDisconnectData{
 			GuildID: guildID,
-			Reason:  protocol.DisconnectReasonConnectionLost,
+			Reason:  reason,
+		},
+	})
+}
+
+func (s *Server) OnVoiceHealthChanged(sessionID string, guildID snowflake.ID, healthy bool) {
+	client := s.getClientBySession(sessionID)
+	if client == nil {
+		return
+	}
+
+	client.send(protocol.Message{
+		Op: protocol.OpVoiceHealth,
+		Data: protocol.VoiceHealthData{
+			GuildID: guildID,
+			Healthy: healthy,
+		},
+	})
+
+	if player := client.getPlayer(guildID); player != nil {
+		client.sendStateChange(guildID, player)
+	}
+}
+
+func (s *Server) OnBufferingChanged(sessionID string, guildID snowflake.ID, buffering bool) {
+	client := s.getClientBySession(sessionID)
+	if client == nil {
+		return
+	}
+
+	client.send(protocol.Message{
+		Op: protocol.OpBuffering,
+		Data: protocol.BufferingData{
+			GuildID:   guildID,
+			Buffering: buffering,
 		},
 	})
 }
 
+func (s *Server) OnVoiceReady(sessionID string, guildID snowflake.ID) {
+	client := s.getClientBySession(sessionID)
+	if client == nil {
+		return
+	}
+
+	client.sendVoiceReady(guildID)
+}
+
+func (s *Server) OnVoiceQueued(sessionID string, guildID snowflake.ID, position int) {
+	client := s.getClientBySession(sessionID)
+	if client == nil {
+		return
+	}
+
+	client.send(protocol.Message{
+		Op:   protocol.OpVoiceQueued,
+		Data: protocol.VoiceQueuedData{GuildID: guildID, Position: position},
+	})
+}
+
 func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	if s.IsDraining() {
 		http.Error(w, "Node is draining", http.StatusServiceUnavailable)
@@ -158,24 +352,40 @@ func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	options, err := s.clientOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	clientName := r.Header.Get("Client-Name")
 	if clientName == "" {
 		clientName = "unknown"
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		s.logger.Error("failed to upgrade websocket", slog.Any("error", err))
 		return
 	}
 
-	client := NewClient(s, conn, clientName)
+	// Clients that don't negotiate a subprotocol predate versioning and speak v1,
+	// but a client that asked for versions we don't know must not be left guessing.
+	if requested := websocket.Subprotocols(r); len(requested) > 0 && conn.Subprotocol() == "" {
+		s.rejectVersion(conn, clientName, requested)
+		return
+	}
+
+	addr := s.clientAddr(r)
+	client := NewClient(s, conn, clientName, addr, options)
 	s.registerClient(client)
 
 	s.logger.Info("client connected",
 		slog.String("client", clientName),
 		slog.String("session", client.sessionID),
-		slog.String("addr", r.RemoteAddr),
+		slog.String("addr", addr),
+		slog.Bool("stats", options.Stats),
+		slog.Bool("state_updates", options.StateUpdates),
 	)
 
 	client.send(protocol.Message{
@@ -183,11 +393,53 @@ func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		Data: protocol.ReadyData{
 			SessionID: client.sessionID,
 			Resumed:   false,
+			Node:      s.nodeName,
+			Capabilities: protocol.Capabilities{
+				ProtocolVersion: protocol.PROTOCOL_VERSION,
+				Sources:         source.EnabledSources(),
+				Filters:         filter.Available(),
+				Features:        protocol.FEATURES,
+				Ops:             protocol.CLIENT_OPS,
+				MaxQueueAdd:     s.maxQueueAdd,
+			},
 		},
 	})
 
 	go client.readPump()
 	go client.writePump()
+	go client.voiceUpdatePump()
+}
+
+func (s *Server) clientOptions(query url.Values) (ClientOptions, error) {
+	heartbeat, err := s.heartbeat.withQuery(query)
+	if err != nil {
+		return ClientOptions{}, err
+	}
+	defaults, err := playerDefaultsFromQuery(query)
+	if err != nil {
+		return ClientOptions{}, err
+	}
+
+	return ClientOptions{
+		// Stats are opt-out so clients that predate the flag keep receiving them.
+		Stats:        query.Get("stats") != "false",
+		StateUpdates: query.Get("state_updates") == "true",
+		Heartbeat:    heartbeat,
+		Defaults:     defaults,
+	}, nil
+}
+
+func (s *Server) rejectVersion(conn *websocket.Conn, clientName string, requested []string) {
+	defer conn.Close()
+
+	s.logger.Warn("client requested unsupported protocol version",
+		slog.String("client", clientName),
+		slog.Any("requested", requested),
+		slog.String("supported", protocol.PROTOCOL_SUBPROTOCOL),
+	)
+
+	msg := websocket.FormatCloseMessage(protocol.CloseUnsupportedVersion, "unsupported protocol version, expected "+protocol.PROTOCOL_SUBPROTOCOL)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(s.heartbeat.WriteTimeout))
 }
 
 func (s *Server) registerClient(client *Client) {
@@ -227,18 +479,61 @@ func (s *Server) handleMessage(client *Client, msgType int, data []byte) {
 
 	switch msg.Op {
 	case protocol.OpVoiceUpdate:
-		s.handleVoiceUpdate(client, msg.Data)
+		select {
+		case client.voiceUpdates <- msg.Data:
+		case <-client.closeChan:
+		}
 	case protocol.OpPlayerMigrate:
 		s.handlePlayerMigrate(client, msg.Data)
 	default:
-		s.logger.Warn("unknown op code", slog.Uint64("op", uint64(msg.Op)))
+		s.logger.Warn("unknown op code",
+			slog.String("session", client.sessionID),
+			slog.Uint64("op", uint64(msg.Op)),
+		)
+		client.sendError(msg.Op, protocol.ErrUnknownOp)
+	}
+}
+
+// decodeOp unmarshals and validates an op's payload, answering with an error
+// op when it can't be handled. Acting on a half-read payload would otherwise
+// create players for guild 0 and the like.
+func (s *Server) decodeOp(client *Client, op uint8, data json.RawMessage, v interface{ Validate() error }) bool {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		err = fmt.Errorf("invalid payload: %w", err)
+	} else {
+		err = v.Validate()
+	}
+	if err == nil {
+		return true
+	}
+
+	s.logger.Warn("rejected client op",
+		slog.String("session", client.sessionID),
+		slog.Uint64("op", uint64(op)),
+		slog.Any("error", err),
+	)
+	client.sendError(op, err)
+	return false
+}
+
+// voiceUpdatePump connects off the read loop, a voice update can wait in the
+// connect queue for long and pongs are only read while the read loop reads.
+// Updates are still handled one at a time, so the newest one wins.
+func (c *Client) voiceUpdatePump() {
+	for {
+		select {
+		case data := <-c.voiceUpdates:
+			c.server.handleVoiceUpdate(c, data)
+		case <-c.closeChan:
+			return
+		}
 	}
 }
 
 func (s *Server) handleVoiceUpdate(client *Client, data json.RawMessage) {
 	var update protocol.VoiceUpdateData
-	if err := json.Unmarshal(data, &update); err != nil {
-		s.logger.Error("failed to unmarshal voice update", slog.Any("error", err))
+	if !s.decodeOp(client, protocol.OpVoiceUpdate, data, &update) {
 		return
 	}
 
@@ -247,10 +542,33 @@ func (s *Server) handleVoiceUpdate(client *Client, data json.RawMessage) {
 		slog.String("channel_id", update.ChannelID.String()),
 	)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if s.refuseNewPlayer(client, update.GuildID) {
+		return
+	}
+
+	ctx, cancel := client.context()
 	defer cancel()
 
 	err := s.voiceManager.Connect(ctx, client.sessionID, update.ClientID, update.GuildID, update.ChannelID, update.SessionID, update.Event)
+	if ctx.Err() != nil {
+		// The client left, likely while its voice update was queued.
+		return
+	}
+	if errors.Is(err, voice.ErrVoiceUpdateSuperseded) {
+		s.logger.Debug("voice update superseded", slog.String("guild_id", update.GuildID.String()))
+		return
+	}
+	if errors.Is(err, voice.ErrGuildOwned) {
+		s.logger.Warn("voice update for a guild owned by another session", slog.String("guild_id", update.GuildID.String()))
+		client.send(protocol.Message{
+			Op: protocol.OpVoiceDisconnect,
+			Data: protocol.VoiceDisconnectData{
+				GuildID: update.GuildID,
+				Reason:  protocol.DisconnectReasonSessionConflict,
+			},
+		})
+		return
+	}
 	if err != nil {
 		s.logger.Error("failed to connect to voice", slog.Any("error", err))
 		client.removePlayer(update.GuildID)
@@ -269,6 +587,7 @@ func (s *Server) handleVoiceUpdate(client *Client, data json.RawMessage) {
 		return
 	}
 
+	detached, reattached := client.reattachPlayer(update.GuildID)
 	player := client.getOrCreatePlayer(update.GuildID)
 	player.SetChannelID(update.ChannelID)
 
@@ -279,22 +598,30 @@ func (s *Server) handleVoiceUpdate(client *Client, data json.RawMessage) {
 			ChannelID: update.ChannelID,
 		},
 	})
+	// A connection that is still reconnecting reports itself once it is done.
+	if s.voiceManager.Ready(client.sessionID, update.GuildID) {
+		client.sendVoiceReady(update.GuildID)
+	}
+
+	if reattached {
+		go s.resumePlayer(client, update.GuildID, detached)
+	}
 }
 
 func (s *Server) handlePlayerMigrate(client *Client, data json.RawMessage) {
 	var migrate protocol.PlayerMigrateData
-	if err := json.Unmarshal(data, &migrate); err != nil {
-		s.logger.Error("failed to unmarshal player migrate", slog.Any("error", err))
+	if !s.decodeOp(client, protocol.OpPlayerMigrate, data, &migrate) {
 		return
 	}
 
 	player := client.getPlayer(migrate.GuildID)
 	if player == nil {
 		s.logger.Warn("player not found for migration", slog.String("guild_id", migrate.GuildID.String()))
+		client.sendError(protocol.OpPlayerMigrate, &protocol.FieldError{Field: "guild_id", Reason: "has no player"})
 		return
 	}
 
-	url, position, state, requesterID, filters := player.GetMigrateData()
+	url, position, state, requesterID, filters, volume := player.GetMigrateData()
 	filters = filters.Normalize()
 	client.send(protocol.Message{
 		Op: protocol.OpMigrateReady,
@@ -305,17 +632,19 @@ func (s *Server) handlePlayerMigrate(client *Client, data json.RawMessage) {
 			State:       state,
 			RequesterID: requesterID,
 			Filters:     filters,
+			Volume:      volume,
 		},
 	})
 
 	s.logger.Info("player migration state sent",
 		slog.String("guild_id", migrate.GuildID.String()),
-		slog.String("url", url),
+		slog.String("url", source.RedactURL(url)),
 	)
 
 	// Remove the player so the old voice connection cleanup
 	// doesn't send a misleading voiceDisconnect to the client.
 	client.removePlayer(migrate.GuildID)
+	s.migratedPlayers.Add(1)
 }
 
 func (s *Server) GetStats() protocol.StatsData {
@@ -338,11 +667,30 @@ func (s *Server) GetStats() protocol.StatsData {
 	runtime.ReadMemStats(&m)
 
 	return protocol.StatsData{
+		Node:          s.nodeName,
 		Players:       totalPlayers,
 		PlayingTracks: playingTracks,
 		Uptime:        time.Since(s.startTime).Milliseconds(),
 		Memory:        m.Alloc,
+		Bandwidth:     s.voiceManager.TotalBandwidth(),
+	}
+}
+
+// ClientStats is sorted by download volume, as upstream bandwidth is what
+// operators usually need to attribute.
+func (s *Server) ClientStats() []protocol.ClientStats {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	result := make([]protocol.ClientStats, 0, len(s.clients))
+	for _, client := range s.clients {
+		result = append(result, client.stats())
 	}
+
+	slices.SortFunc(result, func(a, b protocol.ClientStats) int {
+		return cmp.Compare(b.Bandwidth.DownloadedBytes, a.Bandwidth.DownloadedBytes)
+	})
+	return result
 }
 
 func (s *Server) IsDraining() bool {
@@ -359,16 +707,29 @@ func (s *Server) Drain(reason string, deadlineMs int64) {
 	s.logger.Info("entering drain mode", slog.String("reason", reason), slog.Int64("deadline_ms", deadlineMs))
 
 	s.clientsMu.RLock()
-	for _, client := range s.clients {
-		client.send(protocol.Message{
-			Op: protocol.OpNodeDraining,
-			Data: protocol.NodeDrainingData{
-				Reason:     reason,
-				DeadlineMs: deadlineMs,
-			},
+	clients := slices.Collect(maps.Values(s.clients))
+	s.clientsMu.RUnlock()
+
+	// The notice may wait for room in a busy client's queue, one such client
+	// shouldn't hold it up for the others.
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Go(func() {
+			client.send(protocol.Message{
+				Op: protocol.OpNodeDraining,
+				Data: protocol.NodeDrainingData{
+					Reason:     reason,
+					DeadlineMs: deadlineMs,
+				},
+			})
 		})
 	}
-	s.clientsMu.RUnlock()
+	wg.Wait()
+}
+
+// MigratedPlayers counts players handed off to another node since startup.
+func (s *Server) MigratedPlayers() int64 {
+	return s.migratedPlayers.Load()
 }
 
 func (s *Server) PlayerCount() int {
@@ -0,0 +1,92 @@
+package voice
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// How long setting up a voice connection may take, time spent in the connect
+// queue doesn't count.
+const CONNECT_TIMEOUT = 30 * time.Second
+
+// connectQueue limits how many voice connections are set up at once, so a
+// fleet reconnecting after a restart doesn't hit Discord all at the same time.
+// Waiters are admitted in arrival order, a voice update is never overtaken by
+// later ones.
+type connectQueue struct {
+	mutex  sync.Mutex
+	limit  int
+	active int
+	// Values are chan struct{}, closed when the waiter got its slot.
+	waiters *list.List
+}
+
+func newConnectQueue(limit int) *connectQueue {
+	return &connectQueue{limit: limit, waiters: list.New()}
+}
+
+// acquire calls onQueued with the 1-based queue position when it has to wait.
+// A nil queue never waits.
+func (q *connectQueue) acquire(ctx context.Context, onQueued func(position int)) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	q.mutex.Lock()
+	if q.active < q.limit && q.waiters.Len() == 0 {
+		q.active++
+		q.mutex.Unlock()
+		return q.release, nil
+	}
+	ready := make(chan struct{})
+	el := q.waiters.PushBack(ready)
+	position := q.waiters.Len()
+	q.mutex.Unlock()
+
+	onQueued(position)
+
+	select {
+	case <-ready:
+		return q.release, nil
+	case <-ctx.Done():
+	}
+
+	q.mutex.Lock()
+	select {
+	case <-ready:
+		// The slot was handed over right as the wait ended, pass it on.
+		q.mutex.Unlock()
+		q.release()
+	default:
+		q.waiters.Remove(el)
+		q.mutex.Unlock()
+	}
+	return nil, ctx.Err()
+}
+
+// release hands the slot straight to the next waiter, so a newcomer can't
+// take it first.
+func (q *connectQueue) release() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	front := q.waiters.Front()
+	if front == nil {
+		q.active--
+		return
+	}
+	q.waiters.Remove(front)
+	close(front.Value.(chan struct{}))
+}
+
+func (q *connectQueue) queued() int {
+	if q == nil {
+		return 0
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.waiters.Len()
+}
@@ -2,8 +2,10 @@ package voice
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,11 +14,25 @@ import (
 	"github.com/disgoorg/disgo/gateway"
 	"github.com/disgoorg/disgo/voice"
 	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/audio/filter"
 	"github.com/shi-gg/linkdave/server/audio/source"
 	"github.com/shi-gg/linkdave/server/protocol"
+	"github.com/shi-gg/linkdave/server/recording"
 	"github.com/thomas-vilte/dave-go/session"
 )
 
+var (
+	ErrPlaybackSuperseded = errors.New("playback superseded by a newer request")
+	// The newer voice update applies instead, the caller shouldn't treat it as
+	// a failed connection.
+	ErrVoiceUpdateSuperseded = errors.New("voice update superseded by a newer one")
+	ErrSourcePanicked        = errors.New("source panicked")
+	ErrNoPlayback            = errors.New("no active playback")
+)
+
+// Matches what disgo sends by itself once the provider runs dry on stop.
+const TRANSITION_SILENCE_FRAMES = 5
+
 type Connection struct {
 	logger    *slog.Logger
 	guildID   snowflake.ID
@@ -25,20 +41,82 @@ type Connection struct {
 
 	voiceConn       voice.Conn
 	targetVoiceConn voice.Conn
-
-	source       source.Source
-	onTrackEnd   func(src source.Source, reason string, err error)
-	onDisconnect func()
-	paused       atomic.Bool
-	closed       atomic.Bool
-	mutex        sync.Mutex
-	setupMu      sync.Mutex
+	// voice.NewConn, replaced in tests.
+	newVoiceConn func(guildID, userID snowflake.ID, stateUpdate voice.StateUpdateFunc, removeConn func(), opts ...voice.ConnConfigOpt) voice.Conn
+
+	// Credentials of the live voiceConn, used to detect updates that don't need a new connection.
+	sessionID   string
+	serverEvent protocol.VoiceServerEvent
+
+	source source.Source
+	// Bumped by every play and stop, so a source that finishes loading after a
+	// newer request can tell it is stale instead of overriding it.
+	generation uint64
+
+	// Bytes downloaded by sources that have already been detached.
+	downloaded atomic.Int64
+	sent       atomic.Int64
+	// Stats of sources that have already been detached, guarded by mutex.
+	sourceStats source.Stats
+
+	onTrackEnd     func(src source.Source, reason string, err error)
+	onDisconnect   func(reason string)
+	onHealthChange func(healthy bool)
+	paused         atomic.Bool
+	silenceFrames  atomic.Int32
+	closed         atomic.Bool
+	mutex          sync.Mutex
+	setupMu        sync.Mutex
+	// Bumped by every voice update and reconnect, so one that waited on setupMu
+	// can tell a newer one arrived meanwhile.
+	voiceUpdates atomic.Uint64
 
 	setupCancel context.CancelFunc
 
 	stopChan chan struct{}
 
+	// Unix nanos of the last health check that found the voice gateway alive.
+	lastAlive atomic.Int64
+	healthy   atomic.Bool
+
+	// Reconnect attempts within RECONNECT_WINDOW, only touched while reconnecting.
+	reconnects     []time.Time
+	reconnectCount atomic.Int32
+	reconnecting   atomic.Bool
+
+	// Consecutive frames that failed to send, a reconnect is attempted every
+	// sendFailureThreshold of them.
+	sendFailures         atomic.Int32
+	sendFailureThreshold int
+
+	// Only set when pacing stats are enabled, as it costs a lock per frame.
+	pacing *pacingMonitor
+
 	staleTimer *time.Timer
+
+	createdAt time.Time
+
+	// Rebuffering kicks in after rebufferUnderruns within REBUFFER_WINDOW, 0
+	// disables it. Apart from the flag, only touched by the frame provider.
+	rebufferUnderruns int
+	underrunTimes     []time.Time
+	lastUnderruns     int64
+	rebufferStart     time.Time
+	rebuffering       atomic.Bool
+	onBufferingChange func(buffering bool)
+
+	stutter stutterLog
+
+	// Shared by the connection's tracks, the audio format never changes.
+	encoders *source.EncoderPool
+
+	// Whether voiceConn finished its UDP handshake and can send audio.
+	udpReady atomic.Bool
+	onReady  func()
+
+	recorder atomic.Pointer[recording.Recorder]
+
+	speaking speakingState
 }
 
 func NewConnection(
@@ -48,26 +126,52 @@ func NewConnection(
 	sessionID string,
 	voiceServerEvent protocol.VoiceServerEvent,
 	onTrackEnd func(src source.Source, reason string, err error),
-	onDisconnect func(),
+	onDisconnect func(reason string),
+	onHealthChange func(healthy bool),
+	onBufferingChange func(buffering bool),
+	onReady func(),
+	pacingStats bool,
+	sendFailureThreshold int,
+	rebufferUnderruns int,
 ) (*Connection, error) {
 	conn := &Connection{
-		logger:       logger,
-		guildID:      guildID,
-		channelID:    channelID,
-		userID:       userID,
-		onTrackEnd:   onTrackEnd,
-		onDisconnect: onDisconnect,
-		stopChan:     make(chan struct{}),
+		logger:         logger,
+		guildID:        guildID,
+		channelID:      channelID,
+		userID:         userID,
+		onTrackEnd:     onTrackEnd,
+		onDisconnect:   onDisconnect,
+		onHealthChange: onHealthChange,
+		stopChan:       make(chan struct{}),
+		createdAt:      time.Now(),
+		encoders:       source.NewEncoderPool(),
+		newVoiceConn:   voice.NewConn,
+
+		onBufferingChange:    onBufferingChange,
+		onReady:              onReady,
+		sendFailureThreshold: sendFailureThreshold,
+		rebufferUnderruns:    rebufferUnderruns,
+	}
+	conn.healthy.Store(true)
+	if pacingStats {
+		conn.pacing = &pacingMonitor{}
 	}
+	conn.lastAlive.Store(time.Now().UnixNano())
 
 	if err := conn.setupVoiceConn(ctx, channelID, sessionID, voiceServerEvent); err != nil {
 		return nil, err
 	}
 
+	go conn.monitorHealth()
+
 	return conn, nil
 }
 
-func (c *Connection) setupVoiceConn(ctx context.Context, channelID snowflake.ID, sessionID string, event protocol.VoiceServerEvent) error {
+// lockVoiceUpdate cancels an in-flight open, so the newest update doesn't wait
+// on a connection nobody wants anymore, and takes setupMu. The returned number
+// is checked with superseded.
+func (c *Connection) lockVoiceUpdate() uint64 {
+	update := c.voiceUpdates.Add(1)
 	c.cancelStaleTimer()
 
 	c.mutex.Lock()
@@ -77,8 +181,26 @@ func (c *Connection) setupVoiceConn(ctx context.Context, channelID snowflake.ID,
 	c.mutex.Unlock()
 
 	c.setupMu.Lock()
+	return update
+}
+
+// setupMu isn't fair, so waiting updates can get it out of order.
+func (c *Connection) superseded(update uint64) bool {
+	return c.voiceUpdates.Load() != update
+}
+
+func (c *Connection) setupVoiceConn(ctx context.Context, channelID snowflake.ID, sessionID string, event protocol.VoiceServerEvent) error {
+	update := c.lockVoiceUpdate()
 	defer c.setupMu.Unlock()
 
+	if c.superseded(update) {
+		return ErrVoiceUpdateSuperseded
+	}
+	return c.openVoiceConn(ctx, update, channelID, sessionID, event)
+}
+
+// openVoiceConn is called with setupMu held.
+func (c *Connection) openVoiceConn(ctx context.Context, update uint64, channelID snowflake.ID, sessionID string, event protocol.VoiceServerEvent) error {
 	if c.closed.Load() {
 		return fmt.Errorf("connection closed")
 	}
@@ -88,7 +210,7 @@ func (c *Connection) setupVoiceConn(ctx context.Context, channelID snowflake.ID,
 	c.mutex.Unlock()
 
 	var vc voice.Conn
-	vc = voice.NewConn(
+	vc = c.newVoiceConn(
 		c.guildID,
 		c.userID,
 		func(ctx context.Context, guildID snowflake.ID, channelID *snowflake.ID, selfMute, selfDeaf bool) error {
@@ -122,6 +244,7 @@ func (c *Connection) setupVoiceConn(ctx context.Context, channelID snowflake.ID,
 
 			if current {
 				c.voiceConn = nil
+				c.udpReady.Store(false)
 			}
 
 			if c.targetVoiceConn == vc {
@@ -140,6 +263,7 @@ func (c *Connection) setupVoiceConn(ctx context.Context, channelID snowflake.ID,
 		},
 		voice.WithConnLogger(c.logger),
 		voice.WithConnDaveSessionCreateFunc(session.New),
+		voice.WithConnAudioSenderCreateFunc(c.newAudioSender),
 	)
 
 	openCtx, openCancel := context.WithCancel(ctx)
@@ -150,9 +274,7 @@ func (c *Connection) setupVoiceConn(ctx context.Context, channelID snowflake.ID,
 	c.mutex.Unlock()
 
 	if oldVC != nil {
-		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		oldVC.Close(closeCtx)
-		cancel()
+		closeVoiceConn(oldVC)
 	}
 
 	vc.HandleVoiceStateUpdate(gateway.EventVoiceStateUpdate{
@@ -178,31 +300,52 @@ func (c *Connection) setupVoiceConn(ctx context.Context, channelID snowflake.ID,
 		}
 		c.mutex.Unlock()
 
+		// The voice gateway was already started by the server update and
+		// would otherwise stay connected.
+		closeVoiceConn(vc)
+
+		if c.superseded(update) {
+			return ErrVoiceUpdateSuperseded
+		}
 		return fmt.Errorf("failed to open voice connection: %w", err)
 	}
 
 	if c.closed.Load() {
-		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		vc.Close(closeCtx)
-		cancel()
+		closeVoiceConn(vc)
 		return fmt.Errorf("connection closed during setup")
 	}
 
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	if c.targetVoiceConn != vc {
+		c.mutex.Unlock()
+		closeVoiceConn(vc)
 		return fmt.Errorf("voice connection closed during setup")
 	}
+	defer c.mutex.Unlock()
 
 	c.voiceConn = vc
 	c.targetVoiceConn = nil
+	// Open only returns once the session description arrived.
+	c.udpReady.Store(true)
 	c.channelID = channelID
+	c.sessionID = sessionID
+	c.serverEvent = event
 	vc.SetOpusFrameProvider(&trackWrapper{conn: c})
 
 	return nil
 }
 
+// Ready reports whether the connection can send audio right now.
+func (c *Connection) Ready() bool {
+	return c.udpReady.Load()
+}
+
+func closeVoiceConn(vc voice.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vc.Close(ctx)
+}
+
 func (c *Connection) cancelStaleTimer() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -250,44 +393,117 @@ func (c *Connection) handleUnexpectedDisconnect() {
 	c.logger.Info("sending unexpected disconnect", slog.String("guild_id", c.guildID.String()))
 
 	c.Stop()
-	c.onDisconnect()
+	c.onDisconnect(protocol.DisconnectReasonConnectionLost)
 }
 
 func (c *Connection) HandleVoiceUpdate(ctx context.Context, channelID snowflake.ID, sessionID string, event protocol.VoiceServerEvent) error {
+	update := c.lockVoiceUpdate()
+	defer c.setupMu.Unlock()
+
+	if c.superseded(update) {
+		return ErrVoiceUpdateSuperseded
+	}
+	if c.moveChannel(channelID, sessionID, event) {
+		return nil
+	}
+
+	// New server credentials (a region failover) also take a full reconnect.
+	// disgo's gateway reader blocks on the handshake of a reopened gateway until
+	// Open takes it, so a live connection can't just be pointed elsewhere.
 	c.logger.Info("handling voice update (channel move/server change)",
 		slog.String("guild_id", c.guildID.String()),
 		slog.String("new_channel_id", channelID.String()),
 	)
-	return c.setupVoiceConn(ctx, channelID, sessionID, event)
+	return c.openVoiceConn(ctx, update, channelID, sessionID, event)
 }
 
-func (c *Connection) Play(ctx context.Context, src source.Source) error {
+// A channel move within the same voice server keeps the session and token, so
+// the live connection only needs the new voice state instead of a full
+// reconnect that would interrupt playback. Called with setupMu held.
+func (c *Connection) moveChannel(channelID snowflake.ID, sessionID string, event protocol.VoiceServerEvent) bool {
+	c.mutex.Lock()
+	vc := c.voiceConn
+	sameServer := vc != nil && c.targetVoiceConn == nil && c.sessionID == sessionID && c.serverEvent == event
+	sameChannel := c.channelID == channelID
+	c.mutex.Unlock()
+
+	if !sameServer {
+		return false
+	}
+	if sameChannel {
+		return true
+	}
+
+	c.logger.Info("moving voice channel in place",
+		slog.String("guild_id", c.guildID.String()),
+		slog.String("new_channel_id", channelID.String()),
+	)
+
+	vc.HandleVoiceStateUpdate(gateway.EventVoiceStateUpdate{
+		VoiceState: discord.VoiceState{
+			GuildID:   c.guildID,
+			ChannelID: &channelID,
+			UserID:    c.userID,
+			SessionID: sessionID,
+		},
+	})
+
+	c.mutex.Lock()
+	c.channelID = channelID
+	c.mutex.Unlock()
+
+	return true
+}
+
+// BeginPlay reserves a generation for a play whose source is still loading.
+func (c *Connection) BeginPlay() uint64 {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if c.source != nil {
-		oldSource := c.source
-		c.source = nil
-		oldSource.Close()
+	c.generation++
+	return c.generation
+}
 
-		if c.onTrackEnd != nil {
-			c.onTrackEnd(oldSource, protocol.TrackEndReasonReplaced, nil)
-		}
+// PlayerUpdate fields that are nil are left unchanged.
+type PlayerUpdate struct {
+	Position *int64
+	Paused   *bool
+	Volume   *int
+	// How long the volume glides to the new one, 0 changes it right away.
+	VolumeRampMs int
+	Filters      *filter.Filters
+}
+
+func (c *Connection) Play(ctx context.Context, src source.Source, generation uint64, paused bool) error {
+	c.mutex.Lock()
+	if c.generation != generation {
+		c.mutex.Unlock()
+		return ErrPlaybackSuperseded
+	}
+
+	oldSource := c.detachSource()
+	if oldSource != nil {
+		oldSource.Close()
+		// The new track follows without a gap, so disgo never sends silence
+		// on its own and the listeners' decoders would carry over state.
+		c.silenceFrames.Store(TRANSITION_SILENCE_FRAMES)
 	}
 
 	c.source = src
-	c.paused.Store(false)
+	c.paused.Store(paused)
 
 	select {
 	case <-c.stopChan:
 		c.stopChan = make(chan struct{})
 	default:
 	}
+	c.mutex.Unlock()
 
 	c.logger.Debug("started playback",
 		slog.String("guild_id", c.guildID.String()),
 	)
 
+	c.endTrack(oldSource, protocol.TrackEndReasonReplaced, nil)
 	return nil
 }
 
@@ -300,8 +516,12 @@ func (c *Connection) Resume() {
 }
 
 func (c *Connection) Stop() {
+	c.stop(protocol.TrackEndReasonStopped)
+}
+
+func (c *Connection) stop(reason string) {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	c.generation++
 
 	select {
 	case <-c.stopChan:
@@ -309,14 +529,34 @@ func (c *Connection) Stop() {
 		close(c.stopChan)
 	}
 
-	if c.source != nil {
-		oldSource := c.source
-		c.source = nil
+	oldSource := c.detachSource()
+	c.mutex.Unlock()
+
+	if oldSource != nil {
 		oldSource.Close()
-		if c.onTrackEnd != nil {
-			c.onTrackEnd(oldSource, protocol.TrackEndReasonStopped, nil)
-		}
 	}
+	c.endTrack(oldSource, reason, nil)
+}
+
+// endTrack must be called without the mutex held, the handler ends up in the
+// manager, which reads connections under its own mutex.
+func (c *Connection) endTrack(src source.Source, reason string, err error) {
+	if src == nil || c.onTrackEnd == nil {
+		return
+	}
+	c.onTrackEnd(src, reason, err)
+}
+
+// detachSource must be called with the mutex held.
+func (c *Connection) detachSource() source.Source {
+	src := c.source
+	c.source = nil
+	if src != nil {
+		c.downloaded.Add(src.BytesRead())
+		c.sourceStats = c.sourceStats.Add(src.Stats())
+	}
+	c.setRebuffering(false)
+	return src
 }
 
 func (c *Connection) handleTrackEnd(src source.Source, err error) {
@@ -325,7 +565,7 @@ func (c *Connection) handleTrackEnd(src source.Source, err error) {
 		c.mutex.Unlock()
 		return
 	}
-	c.source = nil
+	c.detachSource()
 	c.mutex.Unlock()
 
 	reason := protocol.TrackEndReasonFinished
@@ -334,10 +574,7 @@ func (c *Connection) handleTrackEnd(src source.Source, err error) {
 	}
 
 	src.Close()
-
-	if c.onTrackEnd != nil {
-		c.onTrackEnd(src, reason, err)
-	}
+	c.endTrack(src, reason, err)
 }
 
 type trackWrapper struct {
@@ -345,6 +582,13 @@ type trackWrapper struct {
 }
 
 func (w *trackWrapper) ProvideOpusFrame() ([]byte, error) {
+	w.conn.markPolled()
+
+	if w.conn.silenceFrames.Load() > 0 {
+		w.conn.silenceFrames.Add(-1)
+		return voice.SilenceAudioFrame, nil
+	}
+
 	w.conn.mutex.Lock()
 	src := w.conn.source
 	w.conn.mutex.Unlock()
@@ -352,9 +596,14 @@ func (w *trackWrapper) ProvideOpusFrame() ([]byte, error) {
 	if src == nil {
 		return nil, nil
 	}
+	// Sources only buffer up to their target ahead of playback, after that a
+	// paused player's HTTP body is held back by TCP flow control instead of piling up.
 	if w.conn.paused.Load() {
 		return nil, nil
 	}
+	if w.conn.rebufferUnderruns > 0 && w.conn.rebuffer(src) {
+		return nil, nil
+	}
 
 	return w.conn.provideOpusFrame(src)
 }
@@ -369,24 +618,210 @@ func (w *trackWrapper) Close() {
 }
 
 func (c *Connection) provideOpusFrame(src source.Source) ([]byte, error) {
-	frame, err := src.ProvideOpusFrame()
+	start := time.Now()
+	frame, err := c.readFrame(src)
 	if err != nil {
 		c.handleTrackEnd(src, err)
+	} else {
+		c.recordStutter(src, time.Since(start))
+	}
+	c.sent.Add(int64(len(frame)))
+	if rec := c.recorder.Load(); rec != nil && len(frame) > 0 {
+		rec.Write(frame)
 	}
 
 	return frame, err
 }
 
-func (c *Connection) SeekTo(positionMs int64) error {
+// readFrame turns a panicking source into a failed track, so a decoder edge
+// case ends this guild's track instead of the send loop or the whole node.
+func (c *Connection) readFrame(src source.Source) (frame []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("source panicked",
+				slog.String("guild_id", c.guildID.String()),
+				slog.Any("panic", r),
+				slog.String("stack", string(debug.Stack())),
+			)
+			frame, err = nil, fmt.Errorf("%w: %v", ErrSourcePanicked, r)
+		}
+	}()
+	return src.ProvideOpusFrame()
+}
+
+// SeekResult tells where a seek landed, sources can only start at a frame so
+// it can be short of the requested position.
+type SeekResult struct {
+	Requested int64
+	Position  int64
+}
+
+func (c *Connection) SeekTo(positionMs int64) (SeekResult, error) {
+	c.mutex.Lock()
+	source := c.source
+	c.mutex.Unlock()
+
+	if source == nil {
+		return SeekResult{}, ErrNoPlayback
+	}
+
+	return seekSource(source, positionMs)
+}
+
+// SeekBy reads the position right before seeking, so the delta applies to
+// where playback actually is rather than to a client's stale view of it.
+func (c *Connection) SeekBy(deltaMs int64) (SeekResult, error) {
+	c.mutex.Lock()
+	source := c.source
+	c.mutex.Unlock()
+
+	if source == nil {
+		return SeekResult{}, ErrNoPlayback
+	}
+
+	return seekSource(source, max(source.Position()+deltaMs, 0))
+}
+
+func seekSource(src source.Source, positionMs int64) (SeekResult, error) {
+	if err := src.SeekTo(positionMs); err != nil {
+		return SeekResult{}, err
+	}
+	return SeekResult{Requested: positionMs, Position: src.Position()}, nil
+}
+
+func (c *Connection) SetFilters(filters *filter.Filters) error {
+	c.mutex.Lock()
+	source := c.source
+	c.mutex.Unlock()
+
+	if source == nil {
+		return ErrNoPlayback
+	}
+
+	source.SetFilters(filters)
+	return nil
+}
+
+// Bandwidth includes the playing source, so long running streams show up
+// before they end.
+func (c *Connection) Bandwidth() protocol.Bandwidth {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	downloaded := c.downloaded.Load()
+	if c.source != nil {
+		downloaded += c.source.BytesRead()
+	}
+
+	return protocol.Bandwidth{
+		DownloadedBytes: downloaded,
+		SentBytes:       c.sent.Load(),
+	}
+}
+
+// SourceStats, like Bandwidth, includes the playing source.
+func (c *Connection) SourceStats() source.Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.sourceStats
+	if c.source != nil {
+		stats = stats.Add(c.source.Stats())
+	}
+	return stats
+}
+
+// Update applies to the source read under the lock, like SeekTo, as a seek
+// can go over the network and the frame provider must not wait for it.
+func (c *Connection) Update(update PlayerUpdate) error {
+	c.mutex.Lock()
+	src := c.source
+	c.mutex.Unlock()
+
+	// Volume outlives the track, so it's only required to have something to apply to.
+	if src == nil && (update.Position != nil || update.Filters != nil) {
+		return ErrNoPlayback
+	}
+
+	if update.Position != nil {
+		if err := src.SeekTo(*update.Position); err != nil {
+			return err
+		}
+	}
+	if update.Filters != nil {
+		src.SetFilters(update.Filters.Normalize())
+	}
+	if update.Volume != nil && src != nil {
+		src.SetVolume(*update.Volume, update.VolumeRampMs)
+	}
+	if update.Paused != nil {
+		c.paused.Store(*update.Paused)
+	}
+
+	return nil
+}
+
+func (c *Connection) Info() protocol.ConnectionInfo {
+	c.mutex.Lock()
+	info := protocol.ConnectionInfo{
+		GuildID:   c.guildID,
+		ChannelID: c.channelID,
+		State:     protocol.ConnectionStateDisconnected,
+		Healthy:   c.healthy.Load(),
+		Playback:  protocol.PlayerStateIdle,
+		UptimeMs:  time.Since(c.createdAt).Milliseconds(),
+		Recording: c.recorder.Load() != nil,
+	}
+	switch {
+	case c.targetVoiceConn != nil:
+		info.State = protocol.ConnectionStateConnecting
+	case c.voiceConn != nil:
+		info.State = protocol.ConnectionStateConnected
+	}
+	src := c.source
+	c.mutex.Unlock()
+
+	if src == nil {
+		return info
+	}
+
+	info.Playback = protocol.PlayerStatePlaying
+	if c.paused.Load() {
+		info.Playback = protocol.PlayerStatePaused
+	}
+	info.URL = source.RedactURL(src.URL())
+	info.Position = src.Position()
+	return info
+}
+
+// Buffer is nil when nothing is playing or the track doesn't buffer ahead.
+func (c *Connection) Buffer() *protocol.BufferStats {
 	c.mutex.Lock()
 	source := c.source
 	c.mutex.Unlock()
 
 	if source == nil {
-		return fmt.Errorf("no active playback")
+		return nil
+	}
+
+	state := source.BufferState()
+	if state.TargetMs == 0 {
+		return nil
+	}
+
+	return &protocol.BufferStats{
+		BufferedMs:    state.BufferedMs,
+		TargetMs:      state.TargetMs,
+		BufferedBytes: state.BufferedBytes,
+		LimitBytes:    state.LimitBytes,
 	}
+}
 
-	return source.SeekTo(positionMs)
+func (c *Connection) PacingStats() *protocol.PacingStats {
+	if c.pacing == nil {
+		return nil
+	}
+	return c.pacing.Stats()
 }
 
 func (c *Connection) Position() int64 {
@@ -402,12 +837,18 @@ func (c *Connection) Position() int64 {
 }
 
 func (c *Connection) Close() {
+	c.close(protocol.TrackEndReasonStopped)
+}
+
+// close ends the playing track with reason.
+func (c *Connection) close(reason string) {
 	if c.closed.Swap(true) {
 		return
 	}
 
 	c.cancelStaleTimer()
-	c.Stop()
+	c.stop(reason)
+	c.StopRecording()
 
 	c.mutex.Lock()
 	if c.setupCancel != nil {
@@ -420,9 +861,6 @@ func (c *Connection) Close() {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	vc.Close(ctx)
+	closeVoiceConn(vc)
 	c.logger.Debug("voice connection closed", slog.String("guild_id", c.guildID.String()))
 }
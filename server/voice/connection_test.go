@@ -0,0 +1,208 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/disgo/voice"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/audio/source"
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+// fakeVoiceConn opens right away, or with hang set, only once its open is canceled.
+type fakeVoiceConn struct {
+	voice.Conn
+	hang    bool
+	opening chan struct{}
+	closed  atomic.Bool
+}
+
+func (*fakeVoiceConn) HandleVoiceStateUpdate(gateway.EventVoiceStateUpdate) {}
+
+func (*fakeVoiceConn) HandleVoiceServerUpdate(gateway.EventVoiceServerUpdate) {}
+
+func (*fakeVoiceConn) SetOpusFrameProvider(voice.OpusFrameProvider) {}
+
+func (c *fakeVoiceConn) Open(ctx context.Context, _ snowflake.ID, _, _ bool) error {
+	close(c.opening)
+	if !c.hang {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (c *fakeVoiceConn) Close(context.Context) {
+	c.closed.Store(true)
+}
+
+func TestBackToBackVoiceUpdatesLeaveOneConnection(t *testing.T) {
+	first := &fakeVoiceConn{hang: true, opening: make(chan struct{})}
+	second := &fakeVoiceConn{opening: make(chan struct{})}
+	conns := make(chan *fakeVoiceConn, 2)
+	conns <- first
+	conns <- second
+
+	c := &Connection{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		newVoiceConn: func(snowflake.ID, snowflake.ID, voice.StateUpdateFunc, func(), ...voice.ConnConfigOpt) voice.Conn {
+			return <-conns
+		},
+	}
+
+	firstErr := make(chan error)
+	go func() {
+		firstErr <- c.setupVoiceConn(context.Background(), 1, "first", protocol.VoiceServerEvent{})
+	}()
+	<-first.opening
+
+	if err := c.setupVoiceConn(context.Background(), 1, "second", protocol.VoiceServerEvent{}); err != nil {
+		t.Fatalf("newest update failed: %v", err)
+	}
+	if err := <-firstErr; !errors.Is(err, ErrVoiceUpdateSuperseded) {
+		t.Fatalf("first update = %v, want ErrVoiceUpdateSuperseded", err)
+	}
+
+	if !first.closed.Load() {
+		t.Fatal("intermediate voice connection was leaked")
+	}
+	if c.voiceConn != second || c.targetVoiceConn != nil || second.closed.Load() {
+		t.Fatal("the newest voice connection isn't the only live one")
+	}
+	if c.sessionID != "second" {
+		t.Fatalf("session = %q, want the newest update's", c.sessionID)
+	}
+}
+
+// frameSource plays frames forever, or panics on the first one.
+type frameSource struct {
+	source.Source
+	panics bool
+}
+
+func (s *frameSource) ProvideOpusFrame() ([]byte, error) {
+	if s.panics {
+		panic("decoder edge case")
+	}
+	return []byte{0xF8, 0xFF, 0xFE}, nil
+}
+
+func (*frameSource) BytesRead() int64 {
+	return 0
+}
+
+func (*frameSource) Stats() source.Stats {
+	return source.Stats{}
+}
+
+func (*frameSource) Close() {}
+
+func newPlayingConnection(src source.Source, ended func(err error)) *Connection {
+	return &Connection{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		source: src,
+		onTrackEnd: func(_ source.Source, _ string, err error) {
+			ended(err)
+		},
+	}
+}
+
+func TestSourcePanicEndsOnlyItsTrack(t *testing.T) {
+	var panickedErr, healthyErr error
+	panicked := newPlayingConnection(&frameSource{panics: true}, func(err error) { panickedErr = err })
+	healthy := newPlayingConnection(&frameSource{}, func(err error) { healthyErr = err })
+
+	if frame, err := panicked.provideOpusFrame(panicked.source); frame != nil || !errors.Is(err, ErrSourcePanicked) {
+		t.Fatalf("panicking frame = (%v, %v), want ErrSourcePanicked", frame, err)
+	}
+	if !errors.Is(panickedErr, ErrSourcePanicked) || panicked.source != nil {
+		t.Fatalf("track end = %v, source = %v, want the track ended with ErrSourcePanicked", panickedErr, panicked.source)
+	}
+
+	for i := range 50 {
+		if frame, err := healthy.provideOpusFrame(healthy.source); len(frame) == 0 || err != nil {
+			t.Fatalf("other player's frame %d = (%v, %v), want audio", i, frame, err)
+		}
+	}
+	if healthyErr != nil || healthy.source == nil {
+		t.Fatalf("other player's track ended: %v", healthyErr)
+	}
+}
+
+// slowSeekSource seeks like a network source, until release is closed.
+type slowSeekSource struct {
+	source.Source
+	seeking chan struct{}
+	release chan struct{}
+}
+
+func (s *slowSeekSource) SeekTo(int64) error {
+	close(s.seeking)
+	<-s.release
+	return nil
+}
+
+func (s *slowSeekSource) Stats() source.Stats {
+	return source.Stats{}
+}
+
+func TestUpdateSeeksOutsideTheLock(t *testing.T) {
+	src := &slowSeekSource{seeking: make(chan struct{}), release: make(chan struct{})}
+	c := &Connection{source: src}
+
+	position := int64(1000)
+	updated := make(chan error)
+	go func() {
+		updated <- c.Update(PlayerUpdate{Position: &position})
+	}()
+	<-src.seeking
+
+	read := make(chan struct{})
+	go func() {
+		c.SourceStats()
+		close(read)
+	}()
+	select {
+	case <-read:
+	case <-time.After(time.Second):
+		t.Fatal("connection stayed locked during the seek")
+	}
+
+	close(src.release)
+	if err := <-updated; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type underrunSource struct {
+	source.Source
+	underruns int64
+}
+
+func (s *underrunSource) Stats() source.Stats {
+	return source.Stats{Underruns: s.underruns}
+}
+
+func TestStutterCountsEachSourceFromZero(t *testing.T) {
+	c := &Connection{}
+	// Keeps the counts from being logged and reset.
+	c.stutter.lastLog = time.Now()
+
+	first := &underrunSource{underruns: 5}
+	c.recordStutter(first, 0)
+	second := &underrunSource{underruns: 7}
+	c.recordStutter(second, 0)
+	second.underruns = 8
+	c.recordStutter(second, 0)
+
+	if got := c.stutter.underruns; got != 13 {
+		t.Fatalf("underruns = %d, want 13", got)
+	}
+}
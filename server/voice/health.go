@@ -0,0 +1,151 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/disgoorg/disgo/voice"
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+const (
+	HEALTH_CHECK_INTERVAL = 5 * time.Second
+
+	// Longer than a voice heartbeat interval, so a gateway that stopped
+	// answering has missed at least one ACK by the time it counts as dead.
+	UNHEALTHY_THRESHOLD = 15 * time.Second
+
+	RECONNECT_TIMEOUT = 10 * time.Second
+
+	// A voice outage makes every reconnect fail, retrying in a loop would only
+	// risk getting rate limited by Discord.
+	MAX_RECONNECTS     = 5
+	RECONNECT_WINDOW   = time.Minute
+	RECONNECT_COOLDOWN = 2 * time.Minute
+)
+
+var ErrReconnectCooldown = errors.New("voice connection for this guild hit the reconnect limit")
+
+// Reconnects is how often the connection reconnected within RECONNECT_WINDOW.
+func (c *Connection) Reconnects() int {
+	return int(c.reconnectCount.Load())
+}
+
+func (c *Connection) allowReconnect() bool {
+	cutoff := time.Now().Add(-RECONNECT_WINDOW)
+	c.reconnects = slices.DeleteFunc(c.reconnects, func(t time.Time) bool {
+		return t.Before(cutoff)
+	})
+	defer func() { c.reconnectCount.Store(int32(len(c.reconnects))) }()
+
+	if len(c.reconnects) >= MAX_RECONNECTS {
+		return false
+	}
+
+	c.reconnects = append(c.reconnects, time.Now())
+	return true
+}
+
+func (c *Connection) Healthy() bool {
+	return c.healthy.Load()
+}
+
+func (c *Connection) markPolled() {
+	if c.pacing != nil {
+		c.recordPacing(time.Now())
+	}
+}
+
+func (c *Connection) monitorHealth() {
+	ticker := time.NewTicker(HEALTH_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if c.closed.Load() {
+			return
+		}
+		c.checkHealth()
+	}
+}
+
+// disgo keeps polling the frame provider and writing to UDP no matter whether
+// anything arrives, so liveness comes from the voice gateway instead. Failed
+// UDP writes are caught separately by the send failure tracker.
+func (c *Connection) checkHealth() {
+	c.mutex.Lock()
+	vc := c.voiceConn
+	connected := vc != nil && c.targetVoiceConn == nil
+	c.mutex.Unlock()
+
+	// The gateway is expected to be down while a setup is in progress.
+	if !connected || gatewayAlive(vc.Gateway()) {
+		c.lastAlive.Store(time.Now().UnixNano())
+		if connected && !c.healthy.Swap(true) && c.onHealthChange != nil {
+			c.onHealthChange(true)
+		}
+		return
+	}
+
+	if time.Since(time.Unix(0, c.lastAlive.Load())) < UNHEALTHY_THRESHOLD {
+		return
+	}
+
+	c.reconnect("voice connection unhealthy, reconnecting")
+}
+
+// Latency goes negative while a heartbeat is waiting for its ACK, which for a
+// live gateway only lasts a round trip.
+func gatewayAlive(gateway voice.Gateway) bool {
+	return gateway.Status() == voice.StatusReady && gateway.Latency() >= 0
+}
+
+// reconnect is shared by the health monitor and the send failure tracker,
+// only one of them reconnects at a time.
+func (c *Connection) reconnect(reason string) {
+	if c.reconnecting.Swap(true) {
+		return
+	}
+	defer c.reconnecting.Store(false)
+
+	c.mutex.Lock()
+	channelID, sessionID, event := c.channelID, c.sessionID, c.serverEvent
+	c.mutex.Unlock()
+
+	if c.healthy.Swap(false) {
+		c.logger.Warn(reason, slog.String("guild_id", c.guildID.String()))
+		if c.onHealthChange != nil {
+			c.onHealthChange(false)
+		}
+	}
+
+	if !c.allowReconnect() {
+		c.logger.Warn("voice connection keeps failing, giving up",
+			slog.String("guild_id", c.guildID.String()),
+			slog.Int("reconnects", len(c.reconnects)),
+		)
+		c.onDisconnect(protocol.DisconnectReasonReconnectLimit)
+		c.Close()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RECONNECT_TIMEOUT)
+	defer cancel()
+
+	err := c.setupVoiceConn(ctx, channelID, sessionID, event)
+	if err == nil && c.onReady != nil {
+		c.onReady()
+	}
+	if err != nil && !errors.Is(err, ErrVoiceUpdateSuperseded) {
+		c.logger.Warn("failed to reconnect unhealthy voice connection",
+			slog.String("guild_id", c.guildID.String()),
+			slog.Any("error", err),
+		)
+	}
+
+	// Gives the new connection a full threshold to become ready before the
+	// next attempt, instead of reconnecting on every tick.
+	c.lastAlive.Store(time.Now().UnixNano())
+}
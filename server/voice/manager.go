@@ -1,38 +1,80 @@
 package voice
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/disgoorg/snowflake/v2"
 	"github.com/shi-gg/linkdave/server/audio/filter"
 	"github.com/shi-gg/linkdave/server/audio/source"
 	"github.com/shi-gg/linkdave/server/protocol"
+	"github.com/shi-gg/linkdave/server/recording"
 )
 
 func connectionKey(sessionID string, guildID snowflake.ID) string {
 	return sessionID + ":" + guildID.String()
 }
 
+func sessionFromKey(key string) string {
+	sessionID, _, _ := strings.Cut(key, ":")
+	return sessionID
+}
+
+// Discord gives a bot one voice connection per guild, so only one session of
+// a bot may own it. Another session's voice update for the guild is refused
+// until the owner disconnects, instead of two connections fighting over it.
+var ErrGuildOwned = errors.New("another session of this bot owns the guild's voice connection")
+
 type EventHandler interface {
 	OnTrackEnd(sessionID string, guildID snowflake.ID, src source.Source, reason string)
 	OnTrackException(sessionID string, guildID snowflake.ID, src source.Source, err error)
-	OnVoiceDisconnected(sessionID string, guildID snowflake.ID)
+	OnVoiceDisconnected(sessionID string, guildID snowflake.ID, reason string)
+	OnVoiceHealthChanged(sessionID string, guildID snowflake.ID, healthy bool)
+	OnBufferingChanged(sessionID string, guildID snowflake.ID, buffering bool)
+	OnVoiceReady(sessionID string, guildID snowflake.ID)
+	OnVoiceQueued(sessionID string, guildID snowflake.ID, position int)
 }
 
 type Manager struct {
-	logger       *slog.Logger
-	connections  map[string]*Connection
-	mutex        sync.RWMutex
-	eventHandler EventHandler
+	logger         *slog.Logger
+	connections    map[string]*Connection
+	connectWaiters map[string][]chan struct{}
+	mutex          sync.RWMutex
+	eventHandler   EventHandler
+	pacingStats    bool
+
+	sendFailureThreshold int
+	rebufferUnderruns    int
+	recording            recording.Config
+	// Nil when connects aren't limited.
+	connectQueue *connectQueue
+
+	// Bandwidth of connections that no longer exist, so node totals don't drop
+	// when a player leaves.
+	retiredMu          sync.Mutex
+	retiredBandwidth   protocol.Bandwidth
+	retiredSourceStats source.Stats
+
+	// Guilds that hit the reconnect limit, until when they can't connect.
+	cooldowns map[snowflake.ID]time.Time
 }
 
 func NewManager(logger *slog.Logger) *Manager {
 	return &Manager{
-		logger:      logger,
-		connections: make(map[string]*Connection),
+		logger:         logger,
+		connections:    make(map[string]*Connection),
+		connectWaiters: make(map[string][]chan struct{}),
+		cooldowns:      make(map[snowflake.ID]time.Time),
+
+		sendFailureThreshold: DEFAULT_SEND_FAILURE_THRESHOLD,
 	}
 }
 
@@ -42,6 +84,37 @@ func (m *Manager) SetEventHandler(handler EventHandler) {
 	m.eventHandler = handler
 }
 
+// EnablePacingStats must be called before any connection is created.
+func (m *Manager) EnablePacingStats() {
+	m.pacingStats = true
+}
+
+// SetSendFailureThreshold must be called before any connection is created,
+// 0 never reconnects because of failed sends.
+func (m *Manager) SetSendFailureThreshold(threshold int) {
+	m.sendFailureThreshold = threshold
+}
+
+// SetRebufferUnderruns must be called before any connection is created, 0
+// keeps playing through underruns.
+func (m *Manager) SetRebufferUnderruns(underruns int) {
+	m.rebufferUnderruns = underruns
+}
+
+// SetConnectConcurrency must be called before any connection is created, 0
+// sets up every connection right away.
+func (m *Manager) SetConnectConcurrency(limit int) {
+	if limit > 0 {
+		m.connectQueue = newConnectQueue(limit)
+	}
+}
+
+// SetRecording must be called before the server accepts requests, recording
+// stays unavailable without it.
+func (m *Manager) SetRecording(config recording.Config) {
+	m.recording = config
+}
+
 func (m *Manager) onTrackEnd(sessionID string, guildID snowflake.ID, src source.Source, reason string, err error) {
 	m.mutex.RLock()
 	handler := m.eventHandler
@@ -58,39 +131,118 @@ func (m *Manager) onTrackEnd(sessionID string, guildID snowflake.ID, src source.
 	handler.OnTrackEnd(sessionID, guildID, src, reason)
 }
 
-func (m *Manager) onDisconnect(sessionID string, guildID snowflake.ID, conn *Connection, key string) {
+func (m *Manager) onDisconnect(sessionID string, guildID snowflake.ID, conn *Connection, key string, reason string) {
 	m.mutex.Lock()
 	if m.connections[key] != conn {
 		m.mutex.Unlock()
 		return
 	}
 	delete(m.connections, key)
+	if reason == protocol.DisconnectReasonReconnectLimit {
+		m.cooldowns[guildID] = time.Now().Add(RECONNECT_COOLDOWN)
+	}
 	handler := m.eventHandler
 	m.mutex.Unlock()
+	m.retire(conn)
+
+	if handler != nil {
+		handler.OnVoiceDisconnected(sessionID, guildID, reason)
+	}
+}
+
+func (m *Manager) onHealthChange(sessionID string, guildID snowflake.ID, healthy bool) {
+	m.mutex.RLock()
+	handler := m.eventHandler
+	m.mutex.RUnlock()
+
+	if handler != nil {
+		handler.OnVoiceHealthChanged(sessionID, guildID, healthy)
+	}
+}
+
+func (m *Manager) onBufferingChange(sessionID string, guildID snowflake.ID, buffering bool) {
+	m.mutex.RLock()
+	handler := m.eventHandler
+	m.mutex.RUnlock()
 
 	if handler != nil {
-		handler.OnVoiceDisconnected(sessionID, guildID)
+		handler.OnBufferingChanged(sessionID, guildID, buffering)
 	}
 }
 
+func (m *Manager) onReady(sessionID string, guildID snowflake.ID) {
+	m.mutex.RLock()
+	handler := m.eventHandler
+	m.mutex.RUnlock()
+
+	if handler != nil {
+		handler.OnVoiceReady(sessionID, guildID)
+	}
+}
+
+func (m *Manager) onConnectQueued(sessionID string, guildID snowflake.ID, position int) {
+	m.mutex.RLock()
+	handler := m.eventHandler
+	m.mutex.RUnlock()
+
+	if handler != nil {
+		handler.OnVoiceQueued(sessionID, guildID, position)
+	}
+}
+
+// Connect only applies CONNECT_TIMEOUT once the connect left the connect
+// queue, so ctx should merely end when the caller is gone.
 func (m *Manager) Connect(ctx context.Context, sessionID string, userID, guildID, channelID snowflake.ID, discordSessionID string, event protocol.VoiceServerEvent) error {
 	m.mutex.Lock()
 	key := connectionKey(sessionID, guildID)
 	existing, ok := m.connections[key]
+	owned := m.ownedElsewhere(sessionID, userID, guildID)
 	m.mutex.Unlock()
 
+	if owned {
+		return ErrGuildOwned
+	}
 	if ok {
+		ctx, cancel := context.WithTimeout(ctx, CONNECT_TIMEOUT)
+		defer cancel()
 		return existing.HandleVoiceUpdate(ctx, channelID, discordSessionID, event)
 	}
 
+	if remaining := m.cooldownRemaining(guildID); remaining > 0 {
+		return fmt.Errorf("%w, retry in %s", ErrReconnectCooldown, remaining.Round(time.Second))
+	}
+
+	release, err := m.connectQueue.acquire(ctx, func(position int) {
+		m.onConnectQueued(sessionID, guildID, position)
+	})
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, CONNECT_TIMEOUT)
+	defer cancel()
+
 	var conn *Connection
-	conn, err := NewConnection(ctx, m.logger, userID, guildID, channelID, discordSessionID, event,
+	conn, err = NewConnection(ctx, m.logger.With(slog.String("session", sessionID)), userID, guildID, channelID, discordSessionID, event,
 		func(src source.Source, reason string, err error) {
 			m.onTrackEnd(sessionID, guildID, src, reason, err)
 		},
+		func(reason string) {
+			m.onDisconnect(sessionID, guildID, conn, key, reason)
+		},
+		func(healthy bool) {
+			m.onHealthChange(sessionID, guildID, healthy)
+		},
+		func(buffering bool) {
+			m.onBufferingChange(sessionID, guildID, buffering)
+		},
 		func() {
-			m.onDisconnect(sessionID, guildID, conn, key)
+			m.onReady(sessionID, guildID)
 		},
+		m.pacingStats,
+		m.sendFailureThreshold,
+		m.rebufferUnderruns,
 	)
 
 	if err != nil {
@@ -103,31 +255,245 @@ func (m *Manager) Connect(ctx context.Context, sessionID string, userID, guildID
 		conn.Close()
 		return existing.HandleVoiceUpdate(ctx, channelID, discordSessionID, event)
 	}
+	// Another session won the race while this one was connecting.
+	if m.ownedElsewhere(sessionID, userID, guildID) {
+		m.mutex.Unlock()
+		conn.Close()
+		return ErrGuildOwned
+	}
 	m.connections[key] = conn
+	for _, ch := range m.connectWaiters[key] {
+		close(ch)
+	}
+	delete(m.connectWaiters, key)
 	m.mutex.Unlock()
 
 	return nil
 }
 
+// WaitForConnection blocks until a voice connection for the guild exists, so a
+// play that races the voice update isn't rejected.
+func (m *Manager) WaitForConnection(ctx context.Context, sessionID string, guildID snowflake.ID) error {
+	key := connectionKey(sessionID, guildID)
+
+	m.mutex.Lock()
+	if _, ok := m.connections[key]; ok {
+		m.mutex.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	m.connectWaiters[key] = append(m.connectWaiters[key], ch)
+	m.mutex.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		m.mutex.Lock()
+		m.connectWaiters[key] = slices.DeleteFunc(m.connectWaiters[key], func(c chan struct{}) bool { return c == ch })
+		if len(m.connectWaiters[key]) == 0 {
+			delete(m.connectWaiters, key)
+		}
+		m.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+// retire keeps the totals of a connection that was removed from the map. It
+// must be called without the mutex held, as connections take their own mutex
+// and call back into the manager while holding it.
+func (m *Manager) retire(conn *Connection) {
+	bandwidth := conn.Bandwidth()
+	stats := conn.SourceStats()
+
+	m.retiredMu.Lock()
+	defer m.retiredMu.Unlock()
+	m.retiredBandwidth = m.retiredBandwidth.Add(bandwidth)
+	m.retiredSourceStats = m.retiredSourceStats.Add(stats)
+}
+
+// activeConnections copies the connections, so they can be read without the
+// mutex held.
+func (m *Manager) activeConnections() []*Connection {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return slices.Collect(maps.Values(m.connections))
+}
+
+func (m *Manager) Bandwidth(sessionID string, guildID snowflake.ID) protocol.Bandwidth {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return protocol.Bandwidth{}
+	}
+
+	return conn.Bandwidth()
+}
+
+func (m *Manager) cooldownRemaining(guildID snowflake.ID) time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	remaining := time.Until(m.cooldowns[guildID])
+	if remaining <= 0 {
+		delete(m.cooldowns, guildID)
+	}
+	return remaining
+}
+
+func (m *Manager) QueuedConnects() int {
+	return m.connectQueue.queued()
+}
+
+func (m *Manager) OpenCircuitBreakers() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	open := 0
+	for _, until := range m.cooldowns {
+		if time.Now().Before(until) {
+			open++
+		}
+	}
+	return open
+}
+
+func (m *Manager) Reconnects(sessionID string, guildID snowflake.ID) int {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return 0
+	}
+
+	return conn.Reconnects()
+}
+
+func (m *Manager) Ready(sessionID string, guildID snowflake.ID) bool {
+	conn := m.getConnection(sessionID, guildID)
+	return conn != nil && conn.Ready()
+}
+
+func (m *Manager) Healthy(sessionID string, guildID snowflake.ID) bool {
+	conn := m.getConnection(sessionID, guildID)
+	return conn != nil && conn.Healthy()
+}
+
+func (m *Manager) Buffer(sessionID string, guildID snowflake.ID) *protocol.BufferStats {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return nil
+	}
+
+	return conn.Buffer()
+}
+
+func (m *Manager) PacingStats(sessionID string, guildID snowflake.ID) *protocol.PacingStats {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return nil
+	}
+
+	return conn.PacingStats()
+}
+
+func (m *Manager) TotalBandwidth() protocol.Bandwidth {
+	conns := m.activeConnections()
+
+	m.retiredMu.Lock()
+	total := m.retiredBandwidth
+	m.retiredMu.Unlock()
+
+	for _, conn := range conns {
+		total = total.Add(conn.Bandwidth())
+	}
+	return total
+}
+
+func (m *Manager) TotalSourceStats() source.Stats {
+	conns := m.activeConnections()
+
+	m.retiredMu.Lock()
+	total := m.retiredSourceStats
+	m.retiredMu.Unlock()
+
+	for _, conn := range conns {
+		total = total.Add(conn.SourceStats())
+	}
+	return total
+}
+
+func (m *Manager) TotalBuffers() protocol.BufferTotals {
+	var total protocol.BufferTotals
+	for _, conn := range m.activeConnections() {
+		if buffer := conn.Buffer(); buffer != nil {
+			total.Sources++
+			total.BufferedBytes += int64(buffer.BufferedBytes)
+			total.LimitBytes += int64(buffer.LimitBytes)
+		}
+	}
+	return total
+}
+
+// Connections is sorted by session and guild, so repeated dumps are easy to diff.
+func (m *Manager) Connections() []protocol.ConnectionInfo {
+	m.mutex.RLock()
+	conns := make(map[string]*Connection, len(m.connections))
+	maps.Copy(conns, m.connections)
+	m.mutex.RUnlock()
+
+	infos := make([]protocol.ConnectionInfo, 0, len(conns))
+	for key, conn := range conns {
+		info := conn.Info()
+		info.SessionID = sessionFromKey(key)
+		infos = append(infos, info)
+	}
+
+	slices.SortFunc(infos, func(a, b protocol.ConnectionInfo) int {
+		return cmp.Or(strings.Compare(a.SessionID, b.SessionID), cmp.Compare(a.GuildID, b.GuildID))
+	})
+	return infos
+}
+
+// ownedElsewhere must be called with the mutex held.
+func (m *Manager) ownedElsewhere(sessionID string, userID, guildID snowflake.ID) bool {
+	for key, conn := range m.connections {
+		if conn.guildID == guildID && conn.userID == userID && sessionFromKey(key) != sessionID {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Manager) getConnection(sessionID string, guildID snowflake.ID) *Connection {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 	return m.connections[connectionKey(sessionID, guildID)]
 }
 
-func (m *Manager) Play(ctx context.Context, sessionID string, guildID snowflake.ID, url string, startTime int64, filters *filter.Filters) (source.Source, error) {
+// Play starts paused when requested, so a restored player doesn't leak a frame
+// before it is paused.
+func (m *Manager) Play(ctx context.Context, sessionID string, guildID snowflake.ID, url string, opts source.Options, paused bool) (source.Source, error) {
 	conn := m.getConnection(sessionID, guildID)
 	if conn == nil {
 		return nil, fmt.Errorf("no voice connection for guild %s", guildID)
 	}
 
+	generation := conn.BeginPlay()
+
+	if source.GetConfig().OpusReuseEncoders {
+		opts.Encoders = conn.encoders
+	}
+
+	// Consults the factories registered with source.RegisterFactory first.
 	factory := source.NewDefaultFactory()
-	src, err := factory.CreateFromURL(ctx, url, startTime, filters)
+	src, err := factory.CreateFromURL(ctx, url, opts)
 	if err != nil {
+		// Canceled by a newer play, a stop or a disconnect.
+		if ctx.Err() != nil {
+			return nil, ErrPlaybackSuperseded
+		}
 		return nil, fmt.Errorf("failed to create audio source: %w", err)
 	}
 
-	if err := conn.Play(ctx, src); err != nil {
+	if err := conn.Play(ctx, src, generation, paused); err != nil {
 		src.Close()
 		return nil, err
 	}
@@ -165,15 +531,42 @@ func (m *Manager) Stop(sessionID string, guildID snowflake.ID) error {
 	return nil
 }
 
-func (m *Manager) Seek(sessionID string, guildID snowflake.ID, position int64) error {
+func (m *Manager) Seek(sessionID string, guildID snowflake.ID, position int64) (SeekResult, error) {
 	conn := m.getConnection(sessionID, guildID)
 	if conn == nil {
-		return fmt.Errorf("no voice connection for guild %s", guildID)
+		return SeekResult{}, fmt.Errorf("no voice connection for guild %s", guildID)
 	}
 
 	return conn.SeekTo(position)
 }
 
+func (m *Manager) SeekBy(sessionID string, guildID snowflake.ID, deltaMs int64) (SeekResult, error) {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return SeekResult{}, fmt.Errorf("no voice connection for guild %s", guildID)
+	}
+
+	return conn.SeekBy(deltaMs)
+}
+
+func (m *Manager) SetFilters(sessionID string, guildID snowflake.ID, filters *filter.Filters) error {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return fmt.Errorf("no voice connection for guild %s", guildID)
+	}
+
+	return conn.SetFilters(filters)
+}
+
+func (m *Manager) Update(sessionID string, guildID snowflake.ID, update PlayerUpdate) error {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return fmt.Errorf("no voice connection for guild %s", guildID)
+	}
+
+	return conn.Update(update)
+}
+
 func (m *Manager) Position(sessionID string, guildID snowflake.ID) int64 {
 	conn := m.getConnection(sessionID, guildID)
 	if conn == nil {
@@ -196,6 +589,7 @@ func (m *Manager) Disconnect(sessionID string, guildID snowflake.ID) error {
 
 	delete(m.connections, key)
 	m.mutex.Unlock()
+	m.retire(conn)
 
 	go func() {
 		conn.Close()
@@ -209,12 +603,20 @@ func (m *Manager) Disconnect(sessionID string, guildID snowflake.ID) error {
 	return nil
 }
 
+// Close is for node shutdown, playing tracks end with the cleanup reason so
+// clients know to move them to another node.
 func (m *Manager) Close() {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
+	conns := make([]*Connection, 0, len(m.connections))
 	for key, conn := range m.connections {
-		conn.Close()
+		conns = append(conns, conn)
 		delete(m.connections, key)
 	}
+	m.mutex.Unlock()
+
+	// Closing fires track end events, which take the mutex.
+	for _, conn := range conns {
+		conn.close(protocol.TrackEndReasonCleanup)
+		m.retire(conn)
+	}
 }
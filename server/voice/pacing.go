@@ -0,0 +1,93 @@
+package voice
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/shi-gg/linkdave/server/protocol"
+)
+
+const (
+	EXPECTED_FRAME_INTERVAL = 20 * time.Millisecond
+	PACING_WINDOW_FRAMES    = 500
+
+	// A few frames of drift per window is scheduler noise, more than that is
+	// audible as stutter or as audio running ahead.
+	PACING_DRIFT_WARN_THRESHOLD = 100 * time.Millisecond
+
+	// Longer gaps are a sender restart, e.g. a reconnect, not a pacing issue.
+	PACING_RESET_GAP = time.Second
+)
+
+// pacingMonitor measures how evenly disgo polls for frames, to tell apart
+// stutter caused by the voice transport from stutter caused by a source.
+type pacingMonitor struct {
+	mutex sync.Mutex
+
+	last        time.Time
+	windowStart time.Time
+	frames      int
+	jitter      time.Duration
+
+	stats *protocol.PacingStats
+}
+
+// record returns the finished window's stats once every PACING_WINDOW_FRAMES polls.
+func (p *pacingMonitor) record(now time.Time) *protocol.PacingStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	interval := now.Sub(p.last)
+	p.last = now
+
+	if interval > PACING_RESET_GAP {
+		p.windowStart = now
+		p.frames = 0
+		p.jitter = 0
+		return nil
+	}
+
+	p.frames++
+	p.jitter += (interval - EXPECTED_FRAME_INTERVAL).Abs()
+	if p.frames < PACING_WINDOW_FRAMES {
+		return nil
+	}
+
+	elapsed := now.Sub(p.windowStart)
+	p.stats = &protocol.PacingStats{
+		MeanIntervalMs: msFloat(elapsed / time.Duration(p.frames)),
+		JitterMs:       msFloat(p.jitter / time.Duration(p.frames)),
+		DriftMs:        msFloat(elapsed - EXPECTED_FRAME_INTERVAL*time.Duration(p.frames)),
+	}
+
+	p.windowStart = now
+	p.frames = 0
+	p.jitter = 0
+	return p.stats
+}
+
+func (p *pacingMonitor) Stats() *protocol.PacingStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stats
+}
+
+func (c *Connection) recordPacing(now time.Time) {
+	stats := c.pacing.record(now)
+	if stats == nil || math.Abs(stats.DriftMs) < msFloat(PACING_DRIFT_WARN_THRESHOLD) {
+		return
+	}
+
+	c.logger.Warn("voice frame pacing drifted",
+		slog.String("guild_id", c.guildID.String()),
+		slog.Float64("drift_ms", stats.DriftMs),
+		slog.Float64("jitter_ms", stats.JitterMs),
+		slog.Float64("mean_interval_ms", stats.MeanIntervalMs),
+	)
+}
+
+func msFloat(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,73 @@
+package voice
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/shi-gg/linkdave/server/audio/source"
+)
+
+const (
+	REBUFFER_WINDOW = 10 * time.Second
+
+	// Resumes even if the buffer never fills, so a stalled origin ends the
+	// track through the normal error path instead of hanging in silence.
+	REBUFFER_TIMEOUT = 10 * time.Second
+)
+
+// rebuffer pauses output after repeated underruns until the source's buffer is
+// full again, one longer gap sounds better than constant stutter. Only called
+// from the frame provider.
+func (c *Connection) rebuffer(src source.Source) bool {
+	state := src.BufferState()
+
+	if c.rebuffering.Load() {
+		if !state.Full && time.Since(c.rebufferStart) < REBUFFER_TIMEOUT {
+			return true
+		}
+		c.setRebuffering(false)
+		return false
+	}
+
+	if state.TargetMs == 0 || !c.recordUnderruns(state.Underruns) {
+		return false
+	}
+
+	c.logger.Info("source keeps underrunning, rebuffering",
+		slog.String("guild_id", c.guildID.String()),
+		slog.Int("underruns", len(c.underrunTimes)),
+	)
+	c.underrunTimes = c.underrunTimes[:0]
+	c.rebufferStart = time.Now()
+	c.setRebuffering(true)
+	return true
+}
+
+func (c *Connection) recordUnderruns(total int64) bool {
+	// A new source starts counting from zero.
+	delta := total - c.lastUnderruns
+	if delta < 0 {
+		delta = total
+	}
+	c.lastUnderruns = total
+
+	now := time.Now()
+	for range min(delta, int64(c.rebufferUnderruns)) {
+		c.underrunTimes = append(c.underrunTimes, now)
+	}
+
+	cutoff := now.Add(-REBUFFER_WINDOW)
+	c.underrunTimes = slices.DeleteFunc(c.underrunTimes, func(t time.Time) bool {
+		return t.Before(cutoff)
+	})
+	return len(c.underrunTimes) >= c.rebufferUnderruns
+}
+
+func (c *Connection) setRebuffering(rebuffering bool) {
+	if c.rebuffering.Swap(rebuffering) == rebuffering || c.onBufferingChange == nil {
+		return
+	}
+	// Called from disgo's audio sender, which must not block on event delivery.
+	go c.onBufferingChange(rebuffering)
+}
@@ -0,0 +1,75 @@
+package voice
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/shi-gg/linkdave/server/recording"
+)
+
+var (
+	ErrRecordingDisabled = errors.New("recording is not enabled on this node")
+	ErrAlreadyRecording  = errors.New("connection is already being recorded")
+	ErrNotRecording      = errors.New("connection is not being recorded")
+	ErrNoConnection      = errors.New("no voice connection")
+)
+
+// StartRecording taps the frames sent to Discord, so the file holds exactly
+// what listeners heard, after filters and volume.
+func (c *Connection) StartRecording(rec *recording.Recorder) error {
+	if c.closed.Load() {
+		return ErrNoConnection
+	}
+	if !c.recorder.CompareAndSwap(nil, rec) {
+		return ErrAlreadyRecording
+	}
+	// A close between the check and the swap stopped recording before rec
+	// was set, nothing else would stop it.
+	if c.closed.Load() {
+		c.recorder.CompareAndSwap(rec, nil)
+		return ErrNoConnection
+	}
+	c.logger.Info("recording started", slog.String("guild_id", c.guildID.String()))
+	return nil
+}
+
+func (c *Connection) StopRecording() bool {
+	rec := c.recorder.Swap(nil)
+	if rec == nil {
+		return false
+	}
+	rec.Close()
+	c.logger.Info("recording stopped", slog.String("guild_id", c.guildID.String()))
+	return true
+}
+
+func (m *Manager) StartRecording(sessionID string, guildID snowflake.ID) error {
+	if !m.recording.Enabled() {
+		return ErrRecordingDisabled
+	}
+
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return ErrNoConnection
+	}
+
+	rec := recording.New(m.logger.With(slog.String("guild_id", guildID.String())), m.recording, guildID.String())
+	if err := conn.StartRecording(rec); err != nil {
+		rec.Close()
+		return err
+	}
+	return nil
+}
+
+func (m *Manager) StopRecording(sessionID string, guildID snowflake.ID) error {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return ErrNoConnection
+	}
+
+	if !conn.StopRecording() {
+		return ErrNotRecording
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package voice
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/shi-gg/linkdave/server/recording"
+)
+
+func TestRecordingNeverOutlivesClose(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for range 200 {
+		c := &Connection{logger: logger, stopChan: make(chan struct{})}
+		rec := recording.New(logger, recording.Config{Dir: t.TempDir()}, "test")
+
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			if err := c.StartRecording(rec); err != nil {
+				rec.Close()
+			}
+		})
+		wg.Go(func() {
+			c.close("test")
+		})
+		wg.Wait()
+
+		if c.recorder.Load() != nil {
+			t.Fatal("recorder left on a closed connection")
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package voice
+
+import (
+	"log/slog"
+
+	"github.com/disgoorg/disgo/voice"
+)
+
+// 1s of frames, short UDP hiccups recover by themselves.
+const DEFAULT_SEND_FAILURE_THRESHOLD = 50
+
+// disgo only logs failed frame writes and keeps going, so the UDP connection
+// is wrapped to notice when audio stops reaching Discord.
+type sendTracker struct {
+	voice.Conn
+	udp  *trackedUDP
+	conn *Connection
+}
+
+func (t *sendTracker) UDP() voice.UDPConn {
+	return t.udp
+}
+
+type trackedUDP struct {
+	voice.UDPConn
+	conn *Connection
+}
+
+func (u *trackedUDP) Write(p []byte) (int, error) {
+	n, err := u.UDPConn.Write(p)
+	u.conn.recordSend(err)
+	return n, err
+}
+
+func (c *Connection) newAudioSender(logger *slog.Logger, provider voice.OpusFrameProvider, conn voice.Conn) voice.AudioSender {
+	tracker := &sendTracker{Conn: conn, udp: &trackedUDP{UDPConn: conn.UDP(), conn: c}, conn: c}
+	return voice.NewAudioSender(logger, provider, tracker)
+}
+
+func (c *Connection) recordSend(err error) {
+	if err == nil {
+		c.sendFailures.Store(0)
+		return
+	}
+
+	if c.sendFailureThreshold <= 0 || c.sendFailures.Add(1)%int32(c.sendFailureThreshold) != 0 {
+		return
+	}
+
+	// Called from disgo's audio sender, which must not block on the reconnect.
+	go c.reconnect("voice frames keep failing to send, reconnecting")
+}
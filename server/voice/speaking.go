@@ -0,0 +1,80 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/disgoorg/disgo/voice"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// speakingState replaces the microphone flag disgo's audio sender announces
+// with the flags a bot asked for, also across voice reconnects.
+type speakingState struct {
+	mutex sync.Mutex
+	flags voice.SpeakingFlags
+	// Whether the sender last announced speaking, rather than silence.
+	active bool
+}
+
+func speakingFlags(priority, soundshare bool) voice.SpeakingFlags {
+	flags := voice.SpeakingFlagMicrophone
+	if priority {
+		flags |= voice.SpeakingFlagPriority
+	}
+	if soundshare {
+		flags |= voice.SpeakingFlagSoundshare
+	}
+	return flags
+}
+
+func (t *sendTracker) SetSpeaking(ctx context.Context, flags voice.SpeakingFlags) error {
+	state := &t.conn.speaking
+	state.mutex.Lock()
+	state.active = flags != voice.SpeakingFlagNone
+	if state.active && state.flags != voice.SpeakingFlagNone {
+		flags = state.flags
+	}
+	state.mutex.Unlock()
+
+	// Sent unlocked, a slow gateway must not hold up SetSpeaking calls.
+	return t.Conn.SetSpeaking(ctx, flags)
+}
+
+// SetSpeaking applies right away while audio is playing, otherwise with the
+// next frame. Priority speaker only takes effect with the Priority Speaker
+// permission in the channel.
+func (c *Connection) SetSpeaking(ctx context.Context, priority, soundshare bool) error {
+	flags := speakingFlags(priority, soundshare)
+
+	c.speaking.mutex.Lock()
+	c.speaking.flags = flags
+	active := c.speaking.active
+	c.speaking.mutex.Unlock()
+
+	if !active {
+		return nil
+	}
+
+	c.mutex.Lock()
+	vc := c.voiceConn
+	c.mutex.Unlock()
+
+	if vc == nil {
+		return nil
+	}
+	if err := vc.SetSpeaking(ctx, flags); err != nil {
+		return fmt.Errorf("set speaking: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) SetSpeaking(ctx context.Context, sessionID string, guildID snowflake.ID, priority, soundshare bool) error {
+	conn := m.getConnection(sessionID, guildID)
+	if conn == nil {
+		return ErrNoConnection
+	}
+
+	return conn.SetSpeaking(ctx, priority, soundshare)
+}
@@ -0,0 +1,63 @@
+package voice
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/shi-gg/linkdave/server/audio/source"
+)
+
+// A sustained bad connection would log every frame, so stutter is logged at
+// most this often per connection. What happened in between is summed up in
+// the next log.
+const STUTTER_LOG_INTERVAL = 10 * time.Second
+
+// A frame that takes longer than its own duration to read arrives late, the
+// listener hears a gap.
+const LATE_FRAME_THRESHOLD = source.OPUS_FRAME_DURATION_MS * time.Millisecond
+
+// stutterLog ties underruns and late frames to a guild and track, so stutter
+// users report can be found in the logs. Only touched by the frame provider.
+type stutterLog struct {
+	// The source lastUnderruns was read from, every source counts from zero.
+	lastSource    source.Source
+	lastUnderruns int64
+	lastLog       time.Time
+	// Since the last log.
+	underruns  int64
+	lateFrames int
+}
+
+func (c *Connection) recordStutter(src source.Source, elapsed time.Duration) {
+	s := &c.stutter
+
+	if src != s.lastSource {
+		s.lastSource = src
+		s.lastUnderruns = 0
+	}
+	total := src.Stats().Underruns
+	s.underruns += total - s.lastUnderruns
+	s.lastUnderruns = total
+	if elapsed > LATE_FRAME_THRESHOLD {
+		s.lateFrames++
+	}
+
+	if s.underruns == 0 && s.lateFrames == 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(s.lastLog) < STUTTER_LOG_INTERVAL {
+		return
+	}
+
+	c.logger.Warn("playback stuttered",
+		slog.String("guild_id", c.guildID.String()),
+		slog.String("url", source.RedactURL(src.URL())),
+		slog.Int64("underruns", s.underruns),
+		slog.Int64("track_underruns", total),
+		slog.Int("late_frames", s.lateFrames),
+	)
+	s.lastLog = now
+	s.underruns = 0
+	s.lateFrames = 0
+}